@@ -0,0 +1,53 @@
+package main
+
+import (
+	"cfddns/config"
+	"cfddns/providers"
+
+	"github.com/sirupsen/logrus"
+)
+
+// exitCheckFailed is the exit code of a check that found problems.
+const exitCheckFailed = 1
+
+// runCheck verifies the credentials and record access of every provider
+// that supports it, without changing any record, and returns the exit code.
+func runCheck(cfg *config.Config) int {
+	failed := 0
+	for _, providerCfg := range cfg.Providers {
+		logger := logrus.WithField("provider", providerCfg.Type)
+
+		provider, err := buildProvider(providerCfg)
+		if err != nil {
+			logger.WithError(err).Errorf("Error configuring provider %s: %v", providerCfg.Type, err)
+			failed++
+			continue
+		}
+		checker, ok := provider.(providers.Checker)
+		if !ok {
+			logger.Infof("No checks available for %s provider", providerCfg.Type)
+			continue
+		}
+
+		records := make([]providers.DNSRecord, 0, len(providerCfg.Records))
+		for _, record := range providerCfg.Records {
+			records = append(records, providers.DNSRecord{
+				Name:    record.Name,
+				Type:    record.Type,
+				Target:  record.Target,
+				Comment: record.Comment,
+			})
+		}
+		if err := checker.Check(records); err != nil {
+			logger.Errorf("Check of %s provider failed: %v", providerCfg.Type, err)
+			failed++
+			continue
+		}
+		logger.Infof("Check of %s provider passed", providerCfg.Type)
+	}
+
+	if failed > 0 {
+		return exitCheckFailed
+	}
+	return exitOK
+}
@@ -4,26 +4,94 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"unicode"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	GeneralSettings GeneralSettings  `yaml:"generalSettings"`
-	Providers       []ProviderConfig `yaml:"providers"`
+	GeneralSettings GeneralSettings      `yaml:"generalSettings"`
+	Notifications   NotificationSettings `yaml:"notifications"`
+	Hooks           HooksConfig          `yaml:"hooks"`
+	Providers       []ProviderConfig     `yaml:"providers"`
+	Path            string               `yaml:"-"`
 }
 
 type GeneralSettings struct {
-	UpdateInterval            int    `yaml:"updateInterval"`
-	ConnectivityCheckInterval int    `yaml:"connectivityCheckInterval"`
-	ConnectivityCheckIP       string `yaml:"connectivityCheckIP"`
-	ConnectivityCheckPort     string `yaml:"connectivityCheckPort"`
+	UpdateInterval            int                  `yaml:"updateInterval"`
+	ConnectivityCheckInterval int                  `yaml:"connectivityCheckInterval"`
+	ConnectivityCheckIP       string               `yaml:"connectivityCheckIP"`
+	ConnectivityCheckPort     string               `yaml:"connectivityCheckPort"`
+	WatchConfig               bool                 `yaml:"watchConfig"`
+	HTTPListen                string               `yaml:"httpListen"`
+	MaxConcurrency            int                  `yaml:"maxConcurrency"`
+	CircuitBreaker            CircuitBreakerConfig `yaml:"circuitBreaker"`
+	MQTT                      *MQTTConfig          `yaml:"mqtt"`
+}
+
+// CircuitBreakerConfig controls when a provider that keeps failing with
+// permanent errors is disabled, and for how long (in seconds).
+type CircuitBreakerConfig struct {
+	FailureThreshold int `yaml:"failureThreshold"`
+	Cooldown         int `yaml:"cooldown"`
+	MaxCooldown      int `yaml:"maxCooldown"`
+}
+
+type MQTTConfig struct {
+	Broker             string `yaml:"broker"`
+	Username           string `yaml:"username"`
+	Password           string `yaml:"password"`
+	ClientID           string `yaml:"clientId"`
+	TopicPrefix        string `yaml:"topicPrefix"`
+	Discovery          bool   `yaml:"discovery"`
+	DiscoveryPrefix    string `yaml:"discoveryPrefix"`
+	CAFile             string `yaml:"caFile"`
+	CertFile           string `yaml:"certFile"`
+	KeyFile            string `yaml:"keyFile"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+type NotificationSettings struct {
+	FailureThreshold int             `yaml:"failureThreshold"`
+	Webhooks         []WebhookConfig `yaml:"webhooks"`
+	Email            *EmailConfig    `yaml:"email"`
+}
+
+type WebhookConfig struct {
+	URL          string            `yaml:"url"`
+	Events       []string          `yaml:"events"`
+	Headers      map[string]string `yaml:"headers"`
+	BodyTemplate string            `yaml:"bodyTemplate"`
+	Secret       string            `yaml:"secret"`
+	Timeout      int               `yaml:"timeout"`
+}
+
+type EmailConfig struct {
+	Host        string   `yaml:"host"`
+	Port        int      `yaml:"port"`
+	Security    string   `yaml:"security"`
+	Username    string   `yaml:"username"`
+	Password    string   `yaml:"password"`
+	From        string   `yaml:"from"`
+	To          []string `yaml:"to"`
+	Events      []string `yaml:"events"`
+	BatchWindow int      `yaml:"batchWindow"`
+}
+
+type HooksConfig struct {
+	Timeout         int    `yaml:"timeout"`
+	OnIPChange      string `yaml:"onIPChange"`
+	OnRecordUpdated string `yaml:"onRecordUpdated"`
+	OnFailure       string `yaml:"onFailure"`
 }
 
 type ProviderConfig struct {
 	Type     string                 `yaml:"type"`
 	Settings map[string]interface{} `yaml:"settings"`
 	Records  []DNSRecord            `yaml:"records"`
+	Source   string                 `yaml:"-"`
 }
 
 type DNSRecord struct {
@@ -32,23 +100,61 @@ type DNSRecord struct {
 	Proxied     bool   `yaml:"proxied,omitempty"`
 	TTL         int    `yaml:"ttl"`
 	UpdateToken string `yaml:"updateToken,omitempty"`
+	Duplicates  string `yaml:"duplicates,omitempty"`
+	Adopt       bool   `yaml:"adopt,omitempty"`
+	Target      string `yaml:"target,omitempty"`
+	Comment     string `yaml:"comment,omitempty"`
+	// Route53 routing policy
+	SetIdentifier string `yaml:"setIdentifier,omitempty"`
+	Weight        *int   `yaml:"weight,omitempty"`
+	Failover      string `yaml:"failover,omitempty"`
+	Region        string `yaml:"region,omitempty"`
+	HealthCheckID string `yaml:"healthCheckId,omitempty"`
 }
 
-func LoadConfig() (*Config, error) {
-	configPath := getConfigFilePath()
+// LoadConfig reads the configuration file at path, or searches the default
+// locations when path is empty, and merges any fragments found in the
+// conf.d directory next to it.
+func LoadConfig(path string) (*Config, error) {
+	configPath := path
+	if configPath == "" {
+		configPath = getConfigFilePath()
+	}
 
 	if configPath == "" {
 		return nil, fmt.Errorf("config file not found")
 	}
 
-	content, err := os.ReadFile(configPath)
+	config, err := readConfigFile(configPath)
 	if err != nil {
-		return nil, fmt.Errorf("error reading config file: %v", err)
+		return nil, err
 	}
+	config.Path = configPath
 
-	var config Config
-	if err := yaml.Unmarshal(content, &config); err != nil {
-		return nil, fmt.Errorf("error unmarshalling yaml: %v", err)
+	fragments, err := filepath.Glob(filepath.Join(FragmentDir(configPath), "*.yml"))
+	if err != nil {
+		return nil, fmt.Errorf("error listing config fragments: %v", err)
+	}
+	// Glob returns matches in lexical order
+	for _, fragmentPath := range fragments {
+		fragment, err := readConfigFile(fragmentPath)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(fragment.GeneralSettings, GeneralSettings{}) {
+			return nil, fmt.Errorf("%s: generalSettings may only be set in the main config file", fragmentPath)
+		}
+		if !reflect.DeepEqual(fragment.Notifications, NotificationSettings{}) {
+			return nil, fmt.Errorf("%s: notifications may only be set in the main config file", fragmentPath)
+		}
+		if fragment.Hooks != (HooksConfig{}) {
+			return nil, fmt.Errorf("%s: hooks may only be set in the main config file", fragmentPath)
+		}
+		config.Providers = append(config.Providers, fragment.Providers...)
+	}
+
+	if err := checkDuplicateRecords(config.Providers); err != nil {
+		return nil, err
 	}
 
 	// Validate general settings with default values
@@ -64,72 +170,363 @@ func LoadConfig() (*Config, error) {
 	if config.GeneralSettings.ConnectivityCheckPort == "" {
 		config.GeneralSettings.ConnectivityCheckPort = "53"
 	}
+	if config.GeneralSettings.MaxConcurrency <= 0 {
+		config.GeneralSettings.MaxConcurrency = 4
+	}
+
+	breaker := &config.GeneralSettings.CircuitBreaker
+	if breaker.FailureThreshold <= 0 {
+		breaker.FailureThreshold = 3
+	}
+	if breaker.Cooldown <= 0 {
+		breaker.Cooldown = 300
+	}
+	if breaker.MaxCooldown <= 0 {
+		breaker.MaxCooldown = 21600
+	}
+	if breaker.MaxCooldown < breaker.Cooldown {
+		breaker.MaxCooldown = breaker.Cooldown
+	}
+
+	if mqtt := config.GeneralSettings.MQTT; mqtt != nil {
+		if mqtt.Broker == "" {
+			return nil, fmt.Errorf("mqtt requires broker")
+		}
+		if mqtt.ClientID == "" {
+			mqtt.ClientID = "cfddns"
+		}
+		if mqtt.TopicPrefix == "" {
+			mqtt.TopicPrefix = "cfddns"
+		}
+		if mqtt.DiscoveryPrefix == "" {
+			mqtt.DiscoveryPrefix = "homeassistant"
+		}
+		if (mqtt.CertFile == "") != (mqtt.KeyFile == "") {
+			return nil, fmt.Errorf("mqtt requires both certFile and keyFile for client certificates")
+		}
+	}
+
+	// Validate notifications
+	if config.Notifications.FailureThreshold <= 0 {
+		config.Notifications.FailureThreshold = 3
+	}
+	for i, webhook := range config.Notifications.Webhooks {
+		if webhook.URL == "" {
+			return nil, fmt.Errorf("webhook notification requires url")
+		}
+		if webhook.Timeout <= 0 {
+			config.Notifications.Webhooks[i].Timeout = 10
+		}
+	}
+
+	if email := config.Notifications.Email; email != nil {
+		if email.Host == "" || email.From == "" || len(email.To) == 0 {
+			return nil, fmt.Errorf("email notification requires host, from and to")
+		}
+		switch email.Security {
+		case "":
+			email.Security = "starttls"
+		case "starttls", "tls", "none":
+		default:
+			return nil, fmt.Errorf("email notification security must be starttls, tls or none")
+		}
+		if email.Port <= 0 {
+			switch email.Security {
+			case "tls":
+				email.Port = 465
+			case "starttls":
+				email.Port = 587
+			default:
+				email.Port = 25
+			}
+		}
+		if email.BatchWindow <= 0 {
+			email.BatchWindow = 300
+		}
+		if len(email.Events) == 0 {
+			email.Events = []string{"ip_changed", "record_failed", "provider_disabled"}
+		}
+	}
+
+	if config.Hooks.Timeout <= 0 {
+		config.Hooks.Timeout = 30
+	}
 
 	// Validate providers
 	for _, provider := range config.Providers {
-		switch provider.Type {
-		case "cloudflare":
-			settings := provider.Settings
-			_, hasAPIToken := settings["apiToken"]
-			_, hasEmail := settings["email"]
-			_, hasGlobalAPIKey := settings["globalApiKey"]
-			if !hasAPIToken && (!hasEmail || !hasGlobalAPIKey) {
-				return nil, fmt.Errorf("cloudflare provider requires either apiToken or both email and globalApiKey")
+		if err := validateProvider(provider); err != nil {
+			return nil, fmt.Errorf("%s: %v", provider.Source, err)
+		}
+	}
+
+	return config, nil
+}
+
+// FragmentDir returns the conf.d directory that accompanies configPath.
+func FragmentDir(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), "conf.d")
+}
+
+func readConfigFile(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %v", err)
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("error unmarshalling yaml in %s: %v", path, err)
+	}
+
+	if err := resolveConfigSecrets(&document); err != nil {
+		return nil, fmt.Errorf("error resolving secrets in %s: %v", path, err)
+	}
+
+	var config Config
+	if err := document.Decode(&config); err != nil {
+		return nil, fmt.Errorf("error unmarshalling yaml in %s: %v", path, err)
+	}
+
+	for i := range config.Providers {
+		config.Providers[i].Source = path
+	}
+
+	return &config, nil
+}
+
+// checkDuplicateRecords rejects the same record being defined twice for a
+// provider type, which usually means two fragments claim the same name.
+// Route53 records in different hosted zones, such as the public and private
+// zone of a split-horizon setup, and routing policy entries with different
+// setIdentifiers are distinct.
+func checkDuplicateRecords(providers []ProviderConfig) error {
+	seen := make(map[string]string)
+	for _, provider := range providers {
+		var zone string
+		if provider.Type == "route53" {
+			zone = zoneIdentity(provider.Settings)
+		}
+		for _, record := range provider.Records {
+			name := strings.ToLower(strings.TrimSuffix(record.Name, "."))
+			key := provider.Type + "/" + zone + "/" + name + "/" + record.Type + "/" + record.SetIdentifier
+			if source, ok := seen[key]; ok {
+				if record.SetIdentifier != "" {
+					return fmt.Errorf("duplicate %s record %s (%s, setIdentifier %s) defined in %s and %s", provider.Type, record.Name, record.Type, record.SetIdentifier, source, provider.Source)
+				}
+				return fmt.Errorf("duplicate %s record %s (%s) defined in %s and %s", provider.Type, record.Name, record.Type, source, provider.Source)
 			}
-		case "route53":
-			settings := provider.Settings
-			_, hasAccessKeyID := settings["accessKeyId"]
-			_, hasSecretAccessKey := settings["secretAccessKey"]
-			if !hasAccessKeyID || !hasSecretAccessKey {
-				return nil, fmt.Errorf("route53 provider requires both accessKeyId and secretAccessKey")
+			seen[key] = provider.Source
+		}
+	}
+	return nil
+}
+
+// zoneIdentity identifies the Route53 hosted zone a provider block writes to
+// from its zoneId, or else its zone name and whether the zone is private.
+// Other providers find the zone from the record name.
+func zoneIdentity(settings map[string]interface{}) string {
+	if zoneID, _ := settings["zoneId"].(string); zoneID != "" {
+		return "id:" + zoneID
+	}
+	zone, _ := settings["zone"].(string)
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+	if private, _ := settings["privateZone"].(bool); private {
+		return zone + ":private"
+	}
+	return zone
+}
+
+func validateProvider(provider ProviderConfig) error {
+	switch provider.Type {
+	case "cloudflare":
+		settings := provider.Settings
+		_, hasAPIToken := settings["apiToken"]
+		_, hasEmail := settings["email"]
+		_, hasGlobalAPIKey := settings["globalApiKey"]
+		if !hasAPIToken && (!hasEmail || !hasGlobalAPIKey) {
+			return fmt.Errorf("cloudflare provider requires either apiToken or both email and globalApiKey")
+		}
+		for _, record := range provider.Records {
+			switch record.Duplicates {
+			case "", "single", "first", "error":
+			default:
+				return fmt.Errorf("cloudflare record %s has unknown duplicates policy %q, expected single, first or error", record.Name, record.Duplicates)
 			}
-		case "digitalocean":
-			settings := provider.Settings
-			_, hasAPIToken := settings["apiToken"]
-			_, hasDomain := settings["domain"]
-			if !hasAPIToken || !hasDomain {
-				return nil, fmt.Errorf("digitalocean provider requires both apiToken and domain")
+			switch record.Target {
+			case "", "dns":
+				if record.Comment != "" {
+					return fmt.Errorf("cloudflare record %s sets comment, which only applies to target ipList", record.Name)
+				}
+			case "ipList":
+				if _, hasAccountID := settings["accountId"]; !hasAccountID {
+					return fmt.Errorf("cloudflare record %s targets an IP list, which requires accountId", record.Name)
+				}
+				if record.Type != "A" && record.Type != "AAAA" {
+					return fmt.Errorf("cloudflare record %s targets an IP list, which requires type A or AAAA", record.Name)
+				}
+				// Every cfddns instance would share a default, and delete the
+				// entries of other sites syncing the same list as stale
+				if strings.TrimSpace(record.Comment) == "" {
+					return fmt.Errorf("cloudflare record %s targets an IP list, which requires a comment unique to this site", record.Name)
+				}
+			default:
+				return fmt.Errorf("cloudflare record %s has unknown target %q, expected dns or ipList", record.Name, record.Target)
 			}
-		case "clouddns":
-			settings := provider.Settings
-			_, hasProjectID := settings["projectId"]
-			_, hasCredentialsJSONPath := settings["credentialsJsonPath"]
-			_, hasZone := settings["zone"]
-			if !hasProjectID || !hasCredentialsJSONPath || !hasZone {
-				return nil, fmt.Errorf("clouddns provider requires projectId, credentialsJsonPath, and zone")
+		}
+		switch markWith, _ := settings["markWith"].(string); markWith {
+		case "", "comment", "tags":
+		default:
+			return fmt.Errorf("cloudflare provider has unknown markWith %q, expected comment or tags", markWith)
+		}
+		if marker, _ := settings["ownershipMarker"].(string); strings.ContainsFunc(marker, unicode.IsSpace) {
+			return fmt.Errorf("cloudflare provider ownershipMarker %q must not contain whitespace", marker)
+		}
+		if enforce, _ := settings["enforceOwnership"].(bool); enforce {
+			if marker, ok := settings["ownershipMarker"].(string); ok && marker == "" {
+				return fmt.Errorf("cloudflare provider requires an ownershipMarker to enforce ownership")
 			}
-		case "duckdns":
-			settings := provider.Settings
-			_, hasToken := settings["token"]
-			if !hasToken {
-				return nil, fmt.Errorf("duckdns provider requires token")
+		}
+	case "route53":
+		settings := provider.Settings
+		_, hasAccessKeyID := settings["accessKeyId"]
+		_, hasSecretAccessKey := settings["secretAccessKey"]
+		if hasAccessKeyID != hasSecretAccessKey {
+			return fmt.Errorf("route53 provider requires both accessKeyId and secretAccessKey, or neither to use the default AWS credential chain")
+		}
+		_, hasRoleARN := settings["roleArn"]
+		_, hasExternalID := settings["externalId"]
+		if hasExternalID && !hasRoleARN {
+			return fmt.Errorf("route53 provider requires roleArn with externalId")
+		}
+		_, hasZone := settings["zone"]
+		_, hasZoneID := settings["zoneId"]
+		if !hasZone && !hasZoneID {
+			return fmt.Errorf("route53 provider requires zone or zoneId")
+		}
+		for _, record := range provider.Records {
+			if err := validateRouting(record); err != nil {
+				return err
 			}
-		case "noip":
-			settings := provider.Settings
-			_, hasUsername := settings["username"]
-			_, hasPassword := settings["password"]
-			if !hasUsername || !hasPassword {
-				return nil, fmt.Errorf("noip provider requires username and password")
+		}
+	case "digitalocean":
+		settings := provider.Settings
+		_, hasAPIToken := settings["apiToken"]
+		_, hasDomain := settings["domain"]
+		if !hasAPIToken || !hasDomain {
+			return fmt.Errorf("digitalocean provider requires both apiToken and domain")
+		}
+	case "clouddns":
+		settings := provider.Settings
+		_, hasProjectID := settings["projectId"]
+		_, hasCredentialsJSONPath := settings["credentialsJsonPath"]
+		_, hasZone := settings["zone"]
+		if !hasProjectID || !hasCredentialsJSONPath || !hasZone {
+			return fmt.Errorf("clouddns provider requires projectId, credentialsJsonPath, and zone")
+		}
+	case "duckdns":
+		settings := provider.Settings
+		_, hasToken := settings["token"]
+		if !hasToken {
+			return fmt.Errorf("duckdns provider requires token")
+		}
+	case "noip":
+		settings := provider.Settings
+		_, hasUsername := settings["username"]
+		_, hasPassword := settings["password"]
+		if !hasUsername || !hasPassword {
+			return fmt.Errorf("noip provider requires username and password")
+		}
+	case "freedns":
+		for _, record := range provider.Records {
+			if record.UpdateToken == "" {
+				return fmt.Errorf("freedns provider requires updateToken per record")
 			}
-		case "freedns":
-			for _, record := range provider.Records {
-				if record.UpdateToken == "" {
-					return nil, fmt.Errorf("freedns provider requires updateToken per record")
-				}
+		}
+	case "dynu":
+		settings := provider.Settings
+		_, hasUsername := settings["username"]
+		_, hasPassword := settings["password"]
+		if !hasUsername || !hasPassword {
+			return fmt.Errorf("dynu provider requires username and password")
+		}
+	default:
+		return fmt.Errorf("unsupported provider type: %s", provider.Type)
+	}
+
+	if provider.Type != "cloudflare" {
+		for _, record := range provider.Records {
+			if record.Duplicates != "" {
+				return fmt.Errorf("%s provider does not support duplicates on record %s", provider.Type, record.Name)
 			}
-		case "dynu":
-			settings := provider.Settings
-			_, hasUsername := settings["username"]
-			_, hasPassword := settings["password"]
-			if !hasUsername || !hasPassword {
-				return nil, fmt.Errorf("dynu provider requires username and password")
+			if record.Adopt {
+				return fmt.Errorf("%s provider does not support adopt on record %s", provider.Type, record.Name)
+			}
+			if record.Target != "" || record.Comment != "" {
+				return fmt.Errorf("%s provider does not support target or comment on record %s", provider.Type, record.Name)
+			}
+		}
+	}
+	if provider.Type != "route53" {
+		for _, record := range provider.Records {
+			if record.SetIdentifier != "" || record.Weight != nil || record.Failover != "" || record.Region != "" || record.HealthCheckID != "" {
+				return fmt.Errorf("%s provider does not support routing policies on record %s", provider.Type, record.Name)
 			}
-		default:
-			return nil, fmt.Errorf("unsupported provider type: %s", provider.Type)
 		}
 	}
 
-	return &config, nil
+	for _, key := range []string{"rateLimit", "rateBurst"} {
+		if _, ok := provider.Settings[key]; !ok {
+			continue
+		}
+		if value, ok := NumberSetting(provider.Settings, key); !ok || value <= 0 {
+			return fmt.Errorf("%s provider requires %s to be a positive number", provider.Type, key)
+		}
+	}
+
+	return nil
+}
+
+// validateRouting checks that a Route53 record uses at most one routing
+// policy, and that it has a set identifier if it does.
+func validateRouting(record DNSRecord) error {
+	policies := 0
+	if record.Weight != nil {
+		policies++
+		if *record.Weight < 0 || *record.Weight > 255 {
+			return fmt.Errorf("route53 record %s has weight %d, expected 0 to 255", record.Name, *record.Weight)
+		}
+	}
+	if record.Failover != "" {
+		policies++
+		if failover := strings.ToUpper(record.Failover); failover != "PRIMARY" && failover != "SECONDARY" {
+			return fmt.Errorf("route53 record %s has unknown failover %q, expected PRIMARY or SECONDARY", record.Name, record.Failover)
+		}
+	}
+	if record.Region != "" {
+		policies++
+	}
+
+	switch {
+	case policies > 1:
+		return fmt.Errorf("route53 record %s sets more than one of weight, failover and region", record.Name)
+	case policies == 1 && record.SetIdentifier == "":
+		return fmt.Errorf("route53 record %s requires setIdentifier with weight, failover or region", record.Name)
+	case policies == 0 && record.SetIdentifier != "":
+		return fmt.Errorf("route53 record %s requires weight, failover or region with setIdentifier", record.Name)
+	}
+	return nil
+}
+
+// NumberSetting returns a numeric provider setting, which YAML decodes as
+// either an int or a float64.
+func NumberSetting(settings map[string]interface{}, key string) (float64, bool) {
+	switch value := settings[key].(type) {
+	case int:
+		return float64(value), true
+	case float64:
+		return value, true
+	}
+	return 0, false
 }
 
 func getConfigFilePath() string {
@@ -0,0 +1,134 @@
+package config
+
+import "testing"
+
+func TestCheckDuplicateRecords(t *testing.T) {
+	provider := func(source, providerType string, settings map[string]interface{}, records ...DNSRecord) ProviderConfig {
+		return ProviderConfig{Type: providerType, Settings: settings, Records: records, Source: source}
+	}
+	public := map[string]interface{}{"zone": "example.com"}
+	private := map[string]interface{}{"zone": "example.com", "privateZone": true}
+	www := DNSRecord{Name: "www.example.com", Type: "A"}
+
+	tests := []struct {
+		name      string
+		providers []ProviderConfig
+		wantErr   bool
+	}{
+		{
+			name: "same record in two fragments",
+			providers: []ProviderConfig{
+				provider("a.yml", "cloudflare", public, www),
+				provider("b.yml", "cloudflare", public, DNSRecord{Name: "WWW.example.com.", Type: "A"}),
+			},
+			wantErr: true,
+		},
+		{
+			name: "cloudflare record by zone and by zoneId",
+			providers: []ProviderConfig{
+				provider("a.yml", "cloudflare", public, www),
+				provider("b.yml", "cloudflare", map[string]interface{}{"zoneId": "023e105f4ecef8ad9ca31a8372d0c353"}, www),
+			},
+			wantErr: true,
+		},
+		{
+			name: "cloudflare record with and without zone",
+			providers: []ProviderConfig{
+				provider("a.yml", "cloudflare", map[string]interface{}{"apiToken": "token"}, www),
+				provider("b.yml", "cloudflare", public, www),
+			},
+			wantErr: true,
+		},
+		{
+			name: "cloudflare records in zone-less blocks",
+			providers: []ProviderConfig{
+				provider("a.yml", "cloudflare", map[string]interface{}{"apiToken": "web"}, www),
+				provider("b.yml", "cloudflare", map[string]interface{}{"apiToken": "ops"}, www),
+			},
+			wantErr: true,
+		},
+		{
+			name: "different record types",
+			providers: []ProviderConfig{
+				provider("a.yml", "cloudflare", public, www, DNSRecord{Name: "www.example.com", Type: "AAAA"}),
+			},
+		},
+		{
+			name: "different provider types",
+			providers: []ProviderConfig{
+				provider("a.yml", "cloudflare", public, www),
+				provider("b.yml", "route53", public, www),
+			},
+		},
+		{
+			name: "failover pair",
+			providers: []ProviderConfig{
+				provider("a.yml", "route53", public,
+					DNSRecord{Name: "www.example.com", Type: "A", SetIdentifier: "primary", Failover: "primary"},
+					DNSRecord{Name: "www.example.com", Type: "A", SetIdentifier: "secondary", Failover: "secondary"}),
+			},
+		},
+		{
+			name: "same setIdentifier",
+			providers: []ProviderConfig{
+				provider("a.yml", "route53", public, DNSRecord{Name: "www.example.com", Type: "A", SetIdentifier: "home"}),
+				provider("b.yml", "route53", public, DNSRecord{Name: "www.example.com", Type: "A", SetIdentifier: "home"}),
+			},
+			wantErr: true,
+		},
+		{
+			name: "split-horizon zones",
+			providers: []ProviderConfig{
+				provider("a.yml", "route53", public, www),
+				provider("b.yml", "route53", private, www),
+			},
+		},
+		{
+			name: "different zone IDs",
+			providers: []ProviderConfig{
+				provider("a.yml", "route53", map[string]interface{}{"zoneId": "Z1"}, www),
+				provider("b.yml", "route53", map[string]interface{}{"zoneId": "Z2"}, www),
+			},
+		},
+		{
+			name: "same zone ID",
+			providers: []ProviderConfig{
+				provider("a.yml", "route53", map[string]interface{}{"zone": "example.com", "zoneId": "Z1"}, www),
+				provider("b.yml", "route53", map[string]interface{}{"zoneId": "Z1"}, www),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDuplicateRecords(tt.providers)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkDuplicateRecords() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateIPListRecords(t *testing.T) {
+	settings := map[string]interface{}{"apiToken": "token", "accountId": "account"}
+	tests := []struct {
+		name    string
+		record  DNSRecord
+		wantErr bool
+	}{
+		{"with comment", DNSRecord{Name: "office_ips", Type: "A", Target: "ipList", Comment: "office uplink"}, false},
+		{"without comment", DNSRecord{Name: "office_ips", Type: "A", Target: "ipList"}, true},
+		{"blank comment", DNSRecord{Name: "office_ips", Type: "AAAA", Target: "ipList", Comment: "  "}, true},
+		{"comment on dns record", DNSRecord{Name: "home.example.com", Type: "A", Comment: "office uplink"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateProvider(ProviderConfig{Type: "cloudflare", Settings: settings, Records: []DNSRecord{tt.record}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateProvider() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
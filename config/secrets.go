@@ -0,0 +1,147 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envReference matches ${NAME} references, and $${ as an escaped literal "${".
+var envReference = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// resolveConfigSecrets resolves secret references in every top-level section
+// except hooks. Hook commands are expanded by the shell when they run, so they
+// can refer to the CFDDNS_* variables of the event.
+func resolveConfigSecrets(document *yaml.Node) error {
+	if document.Kind != yaml.DocumentNode || len(document.Content) != 1 || document.Content[0].Kind != yaml.MappingNode {
+		return resolveSecrets(document)
+	}
+	root := document.Content[0]
+	for i := 1; i < len(root.Content); i += 2 {
+		if root.Content[i-1].Value == "hooks" {
+			continue
+		}
+		if err := resolveSecrets(root.Content[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveSecrets walks a parsed YAML document and replaces secret references
+// in place before it is decoded into Config:
+//
+//	apiToken: ${CF_API_TOKEN}                    # environment variable
+//	secretAccessKey: {file: /run/secrets/aws_key} # file contents
+//	password: {env: NOIP_PASSWORD}                # environment variable
+//	token: {credential: duckdns_token}            # systemd $CREDENTIALS_DIRECTORY
+func resolveSecrets(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			if err := resolveSecrets(child); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		if value, ok, err := secretReference(node); ok {
+			if err != nil {
+				return fmt.Errorf("line %d: %v", node.Line, err)
+			}
+			*node = yaml.Node{
+				Kind:   yaml.ScalarNode,
+				Tag:    "!!str",
+				Value:  value,
+				Line:   node.Line,
+				Column: node.Column,
+			}
+			return nil
+		}
+		// Only values are resolved, mapping keys are left alone
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := resolveSecrets(node.Content[i]); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "${") {
+			return nil
+		}
+		value, err := expandEnv(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", node.Line, err)
+		}
+		node.Value = value
+		if node.Style == 0 {
+			// Let plain scalars be re-resolved so ${TTL} can still decode into an int
+			node.Tag = ""
+		}
+	}
+	return nil
+}
+
+// secretReference reports whether node is a single-key {file|env|credential}
+// mapping and, if so, returns the value it refers to.
+func secretReference(node *yaml.Node) (string, bool, error) {
+	if len(node.Content) != 2 || node.Content[1].Kind != yaml.ScalarNode {
+		return "", false, nil
+	}
+
+	key := node.Content[0].Value
+	arg := node.Content[1].Value
+
+	switch key {
+	case "env":
+		value, ok := os.LookupEnv(arg)
+		if !ok {
+			return "", true, fmt.Errorf("environment variable %s is not set", arg)
+		}
+		return value, true, nil
+	case "file":
+		value, err := readSecretFile(arg)
+		return value, true, err
+	case "credential":
+		credentialsDir := os.Getenv("CREDENTIALS_DIRECTORY")
+		if credentialsDir == "" {
+			return "", true, fmt.Errorf("credential %s requested but CREDENTIALS_DIRECTORY is not set", arg)
+		}
+		if arg == "" || strings.ContainsRune(arg, filepath.Separator) {
+			return "", true, fmt.Errorf("invalid credential name: %q", arg)
+		}
+		value, err := readSecretFile(filepath.Join(credentialsDir, arg))
+		return value, true, err
+	}
+
+	return "", false, nil
+}
+
+func readSecretFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading secret file: %v", err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+func expandEnv(value string) (string, error) {
+	var missing string
+	expanded := envReference.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+		name := match[2 : len(match)-1]
+		envValue, ok := os.LookupEnv(name)
+		if !ok && missing == "" {
+			missing = name
+		}
+		return envValue
+	})
+	if missing != "" {
+		return "", fmt.Errorf("environment variable %s is not set", missing)
+	}
+	return expanded, nil
+}
@@ -2,12 +2,16 @@ package ipfetcher
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"math/rand"
 	"net"
 	"net/http"
 	"strings"
 	"time"
+
+	"cfddns/metrics"
+	"cfddns/status"
 )
 
 func shuffleServices(services []string) []string {
@@ -27,6 +31,43 @@ func isValidIP(ip string, isIPv6 bool) bool {
 	return parsedIP.To4() != nil
 }
 
+// fetchIP asks a single service for the external address and records how long
+// it took.
+func fetchIP(service string, isIPv6 bool) (string, error) {
+	family := "ipv4"
+	if isIPv6 {
+		family = "ipv6"
+	}
+
+	start := time.Now()
+	ip, err := queryService(service, isIPv6)
+	metrics.ObserveIPFetch(service, family, time.Since(start), err)
+	return ip, err
+}
+
+func queryService(service string, isIPv6 bool) (string, error) {
+	resp, err := http.Get(service)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	ip, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	ipStr := strings.TrimSpace(string(ip))
+
+	if !isValidIP(ipStr, isIPv6) {
+		return "", fmt.Errorf("invalid IP address in response: %q", ipStr)
+	}
+	return ipStr, nil
+}
+
 func GetExternalIP() (string, error) {
 	services := []string{
 		"https://api.ipify.org?format=text",
@@ -38,24 +79,15 @@ func GetExternalIP() (string, error) {
 	services = shuffleServices(services)
 
 	for _, service := range services {
-		resp, err := http.Get(service)
-		if err != nil {
-			continue
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode == http.StatusOK {
-			ip, err := io.ReadAll(resp.Body)
-			if err != nil {
-				return "", err
-			}
-			ipStr := strings.TrimSpace(string(ip))
-
-			if isValidIP(ipStr, false) {
-				return ipStr, nil
-			}
+		if ip, err := fetchIP(service, false); err == nil {
+			metrics.SetDetectedIP("ipv4", ip)
+			status.SetIP("ipv4", ip)
+			return ip, nil
 		}
 	}
+	// Don't keep reporting an address that can no longer be confirmed
+	metrics.SetDetectedIP("ipv4", "")
+	status.SetIP("ipv4", "")
 	return "", errors.New("could not fetch a valid external IPv4 address from any service")
 }
 
@@ -69,23 +101,14 @@ func GetExternalIPv6() (string, error) {
 	services = shuffleServices(services)
 
 	for _, service := range services {
-		resp, err := http.Get(service)
-		if err != nil {
-			continue
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode == http.StatusOK {
-			ip, err := io.ReadAll(resp.Body)
-			if err != nil {
-				return "", err
-			}
-			ipStr := strings.TrimSpace(string(ip))
-
-			if isValidIP(ipStr, true) {
-				return ipStr, nil
-			}
+		if ip, err := fetchIP(service, true); err == nil {
+			metrics.SetDetectedIP("ipv6", ip)
+			status.SetIP("ipv6", ip)
+			return ip, nil
 		}
 	}
+	// Don't keep reporting an address that can no longer be confirmed
+	metrics.SetDetectedIP("ipv6", "")
+	status.SetIP("ipv6", "")
 	return "", errors.New("could not fetch a valid external IPv6 address from any service")
 }
@@ -0,0 +1,65 @@
+package logging
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/journal"
+	"github.com/sirupsen/logrus"
+)
+
+var invalidJournalFieldChars = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// journaldHook sends entries to the journal with native fields: PRIORITY,
+// SYSLOG_IDENTIFIER, and each logrus field upper-cased (PROVIDER, RECORD, ...).
+type journaldHook struct{}
+
+func newJournaldHook() (*journaldHook, error) {
+	if !journal.Enabled() {
+		return nil, fmt.Errorf("journald is not available")
+	}
+	return &journaldHook{}, nil
+}
+
+func (h *journaldHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *journaldHook) Fire(entry *logrus.Entry) error {
+	vars := map[string]string{
+		"SYSLOG_IDENTIFIER": Identifier,
+	}
+	for key, value := range entry.Data {
+		vars[journalFieldName(key)] = fieldString(value)
+	}
+	return journal.Send(entry.Message, journalPriority(entry.Level), vars)
+}
+
+// journalFieldName maps a logrus field to a valid journal field name, which
+// must be upper-case and may not start with an underscore.
+func journalFieldName(key string) string {
+	name := invalidJournalFieldChars.ReplaceAllString(strings.ToUpper(key), "_")
+	name = strings.TrimLeft(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "F_" + name
+	}
+	return name
+}
+
+func journalPriority(level logrus.Level) journal.Priority {
+	switch level {
+	case logrus.PanicLevel:
+		return journal.PriEmerg
+	case logrus.FatalLevel:
+		return journal.PriCrit
+	case logrus.ErrorLevel:
+		return journal.PriErr
+	case logrus.WarnLevel:
+		return journal.PriWarning
+	case logrus.InfoLevel:
+		return journal.PriInfo
+	default:
+		return journal.PriDebug
+	}
+}
@@ -0,0 +1,69 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Identifier is the program name reported to journald and syslog.
+const Identifier = "cfddns"
+
+// FileOptions controls rotation of file:<path> log targets.
+type FileOptions struct {
+	MaxSizeMB  int
+	MaxBackups int
+}
+
+// SetTarget points logrus at one of:
+//
+//	stderr                  the default
+//	journald                native journal protocol
+//	syslog                  RFC 5424 to the local syslog socket
+//	syslog:udp://host:514   RFC 5424 to a remote server (also tcp:// and unix://)
+//	file:<path>             a file rotated by size
+func SetTarget(target string, fileOptions FileOptions) error {
+	switch {
+	case target == "" || target == "stderr":
+		logrus.SetOutput(os.Stderr)
+	case target == "journald":
+		hook, err := newJournaldHook()
+		if err != nil {
+			return err
+		}
+		logrus.AddHook(hook)
+		logrus.SetOutput(io.Discard)
+	case target == "syslog" || strings.HasPrefix(target, "syslog:"):
+		hook, err := newSyslogHook(strings.TrimPrefix(strings.TrimPrefix(target, "syslog"), ":"))
+		if err != nil {
+			return err
+		}
+		logrus.AddHook(hook)
+		logrus.SetOutput(io.Discard)
+	case strings.HasPrefix(target, "file:"):
+		path := strings.TrimPrefix(target, "file:")
+		if path == "" {
+			return fmt.Errorf("log target file: requires a path")
+		}
+		logrus.SetOutput(&lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    fileOptions.MaxSizeMB,
+			MaxBackups: fileOptions.MaxBackups,
+		})
+	default:
+		return fmt.Errorf("unsupported log target: %s", target)
+	}
+	return nil
+}
+
+// fieldString renders a logrus field value for journald and syslog.
+func fieldString(value interface{}) string {
+	if err, ok := value.(error); ok {
+		return err.Error()
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,186 @@
+package logging
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// facilityDaemon is the syslog facility used for all messages.
+	facilityDaemon = 3
+	// structuredDataID names the SD-ELEMENT holding logrus fields. 32473 is
+	// the private enterprise number reserved for documentation (RFC 5612).
+	structuredDataID  = "cfddns@32473"
+	syslogDialTimeout = 5 * time.Second
+	// syslogTimestamp is RFC 3339 limited to the microsecond precision RFC 5424 allows.
+	syslogTimestamp = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+var localSyslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// syslogHook writes RFC 5424 messages to a local or remote syslog socket,
+// reconnecting on write errors.
+type syslogHook struct {
+	network  string
+	address  string
+	hostname string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// newSyslogHook connects to the syslog server in address, which is empty for
+// the local socket or a udp://, tcp:// or unix:// URL.
+func newSyslogHook(address string) (*syslogHook, error) {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	hook := &syslogHook{hostname: hostname}
+
+	if address == "" {
+		for _, socket := range localSyslogSockets {
+			if _, err := os.Stat(socket); err == nil {
+				hook.network, hook.address = "unixgram", socket
+				break
+			}
+		}
+		if hook.address == "" {
+			return nil, fmt.Errorf("no local syslog socket found")
+		}
+	} else {
+		u, err := url.Parse(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid syslog address %s: %v", address, err)
+		}
+		switch u.Scheme {
+		case "udp", "tcp":
+			hook.network, hook.address = u.Scheme, u.Host
+			if u.Port() == "" {
+				hook.address = net.JoinHostPort(u.Hostname(), "514")
+			}
+		case "unix":
+			hook.network, hook.address = "unixgram", u.Path
+		default:
+			return nil, fmt.Errorf("unsupported syslog scheme: %s", u.Scheme)
+		}
+	}
+
+	if err := hook.connect(); err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+func (h *syslogHook) connect() error {
+	conn, err := net.DialTimeout(h.network, h.address, syslogDialTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to syslog at %s: %v", h.address, err)
+	}
+	h.conn = conn
+	return nil
+}
+
+func (h *syslogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *syslogHook) Fire(entry *logrus.Entry) error {
+	message := h.format(entry)
+	if h.network == "tcp" {
+		// Octet-counting framing (RFC 6587)
+		message = fmt.Sprintf("%d %s", len(message), message)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.conn != nil {
+		if _, err := h.conn.Write([]byte(message)); err == nil {
+			return nil
+		}
+		h.conn.Close()
+		h.conn = nil
+	}
+	if err := h.connect(); err != nil {
+		return err
+	}
+	_, err := h.conn.Write([]byte(message))
+	return err
+}
+
+// format renders entry as
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [STRUCTURED-DATA] MSG
+func (h *syslogHook) format(entry *logrus.Entry) string {
+	priority := facilityDaemon*8 + syslogSeverity(entry.Level)
+	return fmt.Sprintf("<%d>1 %s %s %s %d - %s %s",
+		priority,
+		entry.Time.Format(syslogTimestamp),
+		h.hostname,
+		Identifier,
+		os.Getpid(),
+		structuredData(entry.Data),
+		entry.Message,
+	)
+}
+
+func structuredData(fields logrus.Fields) string {
+	if len(fields) == 0 {
+		return "-"
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var sd strings.Builder
+	sd.WriteString("[" + structuredDataID)
+	for _, key := range keys {
+		fmt.Fprintf(&sd, " %s=\"%s\"", sdName(key), sdEscape(fieldString(fields[key])))
+	}
+	sd.WriteString("]")
+	return sd.String()
+}
+
+// sdName strips characters RFC 5424 does not allow in a PARAM-NAME.
+func sdName(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return -1
+		}
+		return r
+	}, key)
+}
+
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+func sdEscape(value string) string {
+	return sdEscaper.Replace(value)
+}
+
+func syslogSeverity(level logrus.Level) int {
+	switch level {
+	case logrus.PanicLevel:
+		return 0
+	case logrus.FatalLevel:
+		return 2
+	case logrus.ErrorLevel:
+		return 3
+	case logrus.WarnLevel:
+		return 4
+	case logrus.InfoLevel:
+		return 6
+	default:
+		return 7
+	}
+}
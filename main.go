@@ -2,47 +2,84 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
 	"syscall"
 	"time"
 
 	"cfddns/config"
 	"cfddns/ipfetcher"
-	"cfddns/providers"
-	"cfddns/providers/clouddns"
-	"cfddns/providers/cloudflare"
-	"cfddns/providers/digitalocean"
-	"cfddns/providers/duckdns"
-	"cfddns/providers/dynu"
-	"cfddns/providers/freedns"
-	"cfddns/providers/noip"
-	"cfddns/providers/route53"
+	"cfddns/logging"
+	"cfddns/metrics"
+	"cfddns/mqtt"
+	"cfddns/notify"
+	"cfddns/status"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/sirupsen/logrus"
 )
 
 func main() {
 	runAsDaemon := flag.Bool("daemon", false, "Run as a daemon service")
 	verbose := flag.Bool("verbose", false, "Enable verbose logging")
+	configPath := flag.String("config", "", "Path to the configuration file")
+	logFormat := flag.String("log-format", "text", "Log format: text or json")
+	logTarget := flag.String("log-target", "stderr", "Log target: stderr, journald, syslog[:udp|tcp|unix://address] or file:<path>")
+	logMaxSize := flag.Int("log-max-size", 10, "Size in megabytes at which a file log target is rotated")
+	logMaxBackups := flag.Int("log-max-backups", 5, "Number of rotated log files to keep")
+	output := flag.String("output", "text", "Result output of a one-shot run: text (log only) or json (summary on stdout)")
 	flag.Parse()
 
-	setupLogging(*verbose, *runAsDaemon)
+	// Flags may also follow the command, as in `cfddns check -config path`
+	command := flag.Arg(0)
+	if command != "" {
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
+
+	if err := setupLogging(*verbose, *runAsDaemon, *logFormat); err != nil {
+		logrus.Fatalf("Error setting up logging: %v", err)
+	}
+	if err := logging.SetTarget(*logTarget, logging.FileOptions{MaxSizeMB: *logMaxSize, MaxBackups: *logMaxBackups}); err != nil {
+		logrus.Fatalf("Error setting up logging: %v", err)
+	}
+	if *output != "text" && *output != "json" {
+		logrus.Fatalf("Unsupported output format: %s", *output)
+	}
+	if command != "" && command != "check" {
+		logrus.Fatalf("Unknown command: %s", command)
+	}
 
-	cfg, err := config.LoadConfig()
+	cfg, err := config.LoadConfig(*configPath)
 	if err != nil {
 		logrus.Fatalf("Error loading configuration: %v", err)
 	}
 
-	if *runAsDaemon {
+	if command == "check" {
+		os.Exit(runCheck(cfg))
+	} else if *runAsDaemon {
 		runDaemon(cfg)
 	} else {
-		runOnce(cfg)
+		notifier, err := notify.New(cfg.Notifications, cfg.Hooks)
+		if err != nil {
+			logrus.Fatalf("Error configuring notifications: %v", err)
+		}
+		summary := runOnce(cfg, notifier)
+		notifier.Close()
+
+		if *output == "json" {
+			if err := summary.writeJSON(os.Stdout); err != nil {
+				logrus.Errorf("Error writing summary: %v", err)
+			}
+		}
+		os.Exit(summary.exitCode())
 	}
 }
 
-func setupLogging(verbose, runAsDaemon bool) {
+func setupLogging(verbose, runAsDaemon bool, format string) error {
 	// Set logging level based on verbosity
 	if verbose {
 		logrus.SetLevel(logrus.DebugLevel)
@@ -51,6 +88,13 @@ func setupLogging(verbose, runAsDaemon bool) {
 	}
 
 	// Set up log formatter
+	if format == "json" {
+		logrus.SetFormatter(&logrus.JSONFormatter{TimestampFormat: time.RFC3339})
+		return nil
+	} else if format != "text" {
+		return fmt.Errorf("unsupported log format: %s", format)
+	}
+
 	formatter := &logrus.TextFormatter{
 		FullTimestamp:   true,
 		TimestampFormat: time.RFC3339,
@@ -62,157 +106,18 @@ func setupLogging(verbose, runAsDaemon bool) {
 	}
 
 	logrus.SetFormatter(formatter)
-}
-
-func runOnce(cfg *config.Config) {
-	ipv4Address, err := ipfetcher.GetExternalIP()
-	if err != nil {
-		logrus.Warnf("Error fetching external IPv4 address: %v", err)
-		ipv4Address = ""
-	}
-
-	ipv6Address, err := ipfetcher.GetExternalIPv6()
-	if err != nil {
-		logrus.Warnf("Error fetching external IPv6 address: %v", err)
-		ipv6Address = ""
-	}
-
-	for _, providerCfg := range cfg.Providers {
-		var provider providers.Provider
-
-		switch providerCfg.Type {
-		case "cloudflare":
-			settings := providerCfg.Settings
-
-			email, _ := settings["email"].(string)
-			apiToken, _ := settings["apiToken"].(string)
-			globalAPIKey, _ := settings["globalApiKey"].(string)
-			zoneName, _ := settings["zone"].(string)
-
-			provider = &cloudflare.CloudflareProvider{
-				Email:        email,
-				APIToken:     apiToken,
-				GlobalAPIKey: globalAPIKey,
-				ZoneName:     zoneName,
-			}
-
-		case "route53":
-			settings := providerCfg.Settings
-
-			zoneName, _ := settings["zone"].(string)
-			region, _ := settings["region"].(string)
-			accessKeyID, _ := settings["accessKeyId"].(string)
-			secretAccessKey, _ := settings["secretAccessKey"].(string)
-			if region == "" {
-				region = "us-east-1"
-			}
-
-			provider = &route53.Route53Provider{
-				ZoneName:        zoneName,
-				Region:          region,
-				AccessKeyID:     accessKeyID,
-				SecretAccessKey: secretAccessKey,
-			}
-
-		case "digitalocean":
-			settings := providerCfg.Settings
-			apiToken, _ := settings["apiToken"].(string)
-			domain, _ := settings["domain"].(string)
-
-			provider = &digitalocean.DigitalOceanProvider{
-				APIToken: apiToken,
-				Domain:   domain,
-			}
-
-		case "clouddns":
-			settings := providerCfg.Settings
-			projectID, _ := settings["projectId"].(string)
-			credentialsJSONPath, _ := settings["credentialsJsonPath"].(string)
-			zoneName, _ := settings["zone"].(string)
-
-			// Read the credentials JSON file
-			credentialsJSON, err := os.ReadFile(credentialsJSONPath)
-			if err != nil {
-				logrus.Errorf("Failed to read credentials JSON file: %v", err)
-				continue
-			}
-
-			provider = &clouddns.CloudDNSProvider{
-				ProjectID:       projectID,
-				CredentialsJSON: credentialsJSON,
-				ZoneName:        zoneName,
-			}
-
-		case "duckdns":
-			settings := providerCfg.Settings
-			token, _ := settings["token"].(string)
-
-			provider = &duckdns.DuckDNSProvider{
-				Token: token,
-			}
-
-		case "noip":
-			settings := providerCfg.Settings
-			username, _ := settings["username"].(string)
-			password, _ := settings["password"].(string)
-
-			provider = &noip.NoIPProvider{
-				Username: username,
-				Password: password,
-			}
-
-		case "freedns":
-			provider = &freedns.FreeDNSProvider{}
-
-		case "dynu":
-			settings := providerCfg.Settings
-			username, _ := settings["username"].(string)
-			password, _ := settings["password"].(string)
-
-			provider = &dynu.DynuProvider{
-				Username: username,
-				Password: password,
-			}
-
-		default:
-			logrus.Errorf("Unsupported provider type: %s", providerCfg.Type)
-			continue
-		}
-
-		for _, record := range providerCfg.Records {
-			var ipAddress string
-
-			if record.Type == "A" && ipv4Address != "" {
-				ipAddress = ipv4Address
-			} else if record.Type == "AAAA" && ipv6Address != "" {
-				ipAddress = ipv6Address
-			} else {
-				logrus.Warnf("Skipping record %s of type %s due to missing IP", record.Name, record.Type)
-				continue
-			}
-
-			dnsRecord := providers.DNSRecord{
-				Name:        record.Name,
-				Type:        record.Type,
-				Content:     ipAddress,
-				TTL:         record.TTL,
-				Proxied:     record.Proxied,
-				UpdateToken: record.UpdateToken,
-			}
-
-			err := provider.CommitRecord(dnsRecord)
-			if err != nil {
-				logrus.Errorf("Error updating DNS record for %s: %v", record.Name, err)
-			}
-		}
-	}
+	return nil
 }
 
 func runDaemon(cfg *config.Config) {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+
 	done := make(chan bool, 1)
+	reload := make(chan struct{}, 1)
 
 	updateInterval := time.Duration(cfg.GeneralSettings.UpdateInterval) * time.Second
 	connectivityCheckInterval := time.Duration(cfg.GeneralSettings.ConnectivityCheckInterval) * time.Second
@@ -221,17 +126,82 @@ func runDaemon(cfg *config.Config) {
 	var isConnected bool
 
 	updateTimer := time.NewTimer(updateInterval)
+	status.SetNextCheck(time.Now().Add(updateInterval))
 	connectivityTicker := time.NewTicker(connectivityCheckInterval)
 
 	defer updateTimer.Stop()
 	defer connectivityTicker.Stop()
 
+	notifier, err := notify.New(cfg.Notifications, cfg.Hooks)
+	if err != nil {
+		logrus.Fatalf("Error configuring notifications: %v", err)
+	}
+	defer func() {
+		notifier.Close()
+	}()
+
+	var publisher *mqtt.Publisher
+	var mqttCommands <-chan struct{}
+	startMQTT := func() {
+		if cfg.GeneralSettings.MQTT == nil {
+			return
+		}
+		var err error
+		publisher, err = mqtt.New(*cfg.GeneralSettings.MQTT)
+		if err != nil {
+			logrus.Errorf("Error starting MQTT publisher: %v", err)
+			return
+		}
+		mqttCommands = publisher.Commands()
+	}
+	stopMQTT := func() {
+		if publisher != nil {
+			publisher.Close()
+			publisher = nil
+			mqttCommands = nil
+		}
+	}
+	syncMQTT := func() {
+		if publisher != nil {
+			publisher.Sync(status.Snapshot())
+		}
+	}
+	startMQTT()
+	defer stopMQTT()
+
+	var watcher *fsnotify.Watcher
+	startWatcher := func() {
+		if !cfg.GeneralSettings.WatchConfig || watcher != nil {
+			return
+		}
+		var err error
+		watcher, err = watchConfig(cfg.Path, reload)
+		if err != nil {
+			logrus.Warnf("Error watching configuration file %s: %v", cfg.Path, err)
+		}
+	}
+	startWatcher()
+	defer func() {
+		if watcher != nil {
+			watcher.Close()
+		}
+	}()
+
+	var server *http.Server
+	if cfg.GeneralSettings.HTTPListen != "" {
+		server = startHTTPServer(cfg.GeneralSettings.HTTPListen)
+		defer stopHTTPServer(server)
+	}
+
 	// Immediate connectivity check and update
 	connected := isInternetAvailable(cfg)
+	metrics.SetConnected(connected)
+	status.SetConnected(connected)
+	status.Heartbeat(connectivityCheckInterval)
 	if connected {
 		isConnected = true
 		logrus.Info("Daemon started and internet connection is available. Updating DNS records.")
-		runOnce(cfg)
+		runOnce(cfg, notifier)
 		lastIPV4, _ = ipfetcher.GetExternalIP()
 		lastIPV6, _ = ipfetcher.GetExternalIPv6()
 	} else {
@@ -239,22 +209,41 @@ func runDaemon(cfg *config.Config) {
 		logrus.Warn("Daemon started but no internet connection is available.")
 	}
 
+	syncMQTT()
+
 	go func() {
 		sig := <-sigs
 		logrus.Infof("Received signal: %v, shutting down gracefully...", sig)
 		done <- true
 	}()
 
+	go func() {
+		for sig := range hups {
+			logrus.Infof("Received signal: %v, reloading configuration...", sig)
+			select {
+			case reload <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
 	for {
 		select {
 		case <-connectivityTicker.C:
 			connected := isInternetAvailable(cfg)
+			metrics.SetConnected(connected)
+			status.SetConnected(connected)
+			status.Heartbeat(connectivityCheckInterval)
 			if connected && !isConnected {
 				isConnected = true
 				logrus.Info("Internet connection restored. Updating DNS records.")
-				runOnce(cfg)
-				lastIPV4, _ = ipfetcher.GetExternalIP()
-				lastIPV6, _ = ipfetcher.GetExternalIPv6()
+				ipv4Address, _ := ipfetcher.GetExternalIP()
+				ipv6Address, _ := ipfetcher.GetExternalIPv6()
+				notifyIPChange(notifier, "ipv4", lastIPV4, ipv4Address)
+				notifyIPChange(notifier, "ipv6", lastIPV6, ipv6Address)
+				runOnce(cfg, notifier)
+				lastIPV4 = ipv4Address
+				lastIPV6 = ipv6Address
 				// Reset the update timer
 				if !updateTimer.Stop() {
 					select {
@@ -263,6 +252,7 @@ func runDaemon(cfg *config.Config) {
 					}
 				}
 				updateTimer.Reset(updateInterval)
+				status.SetNextCheck(time.Now().Add(updateInterval))
 			} else if !connected && isConnected {
 				isConnected = false
 				logrus.Warn("Internet connection lost.")
@@ -271,19 +261,21 @@ func runDaemon(cfg *config.Config) {
 			if isConnected {
 				ipv4Address, err := ipfetcher.GetExternalIP()
 				if err != nil {
-					logrus.Warnf("Error fetching external IPv4 address: %v", err)
+					logrus.WithField("family", "ipv4").WithError(err).Warnf("Error fetching external IPv4 address: %v", err)
 					ipv4Address = ""
 				}
 
 				ipv6Address, err := ipfetcher.GetExternalIPv6()
 				if err != nil {
-					logrus.Warnf("Error fetching external IPv6 address: %v", err)
+					logrus.WithField("family", "ipv6").WithError(err).Warnf("Error fetching external IPv6 address: %v", err)
 					ipv6Address = ""
 				}
 
 				if ipv4Address != lastIPV4 || ipv6Address != lastIPV6 {
 					logrus.Info("IP address changed. Updating DNS records.")
-					runOnce(cfg)
+					notifyIPChange(notifier, "ipv4", lastIPV4, ipv4Address)
+					notifyIPChange(notifier, "ipv6", lastIPV6, ipv6Address)
+					runOnce(cfg, notifier)
 					lastIPV4 = ipv4Address
 					lastIPV6 = ipv6Address
 				} else {
@@ -291,14 +283,90 @@ func runDaemon(cfg *config.Config) {
 				}
 				// Reset the update timer
 				updateTimer.Reset(updateInterval)
+				status.SetNextCheck(time.Now().Add(updateInterval))
 			} else {
 				// If not connected, reset the update timer to wait for the next interval
 				updateTimer.Reset(updateInterval)
+				status.SetNextCheck(time.Now().Add(updateInterval))
+			}
+		case <-reload:
+			newCfg, newNotifier, ok := reloadConfig(cfg)
+			if !ok {
+				continue
+			}
+			oldCfg := cfg
+			cfg = newCfg
+			notifier.Close()
+			notifier = newNotifier
+			pruneProviders(cfg)
+			pruneRecordStatus(cfg)
+
+			if watcher != nil && !cfg.GeneralSettings.WatchConfig {
+				watcher.Close()
+				watcher = nil
+			}
+			startWatcher()
+
+			if !reflect.DeepEqual(cfg.GeneralSettings.MQTT, oldCfg.GeneralSettings.MQTT) {
+				stopMQTT()
+				startMQTT()
 			}
+
+			if cfg.GeneralSettings.HTTPListen != oldCfg.GeneralSettings.HTTPListen {
+				logrus.Warn("Changes to httpListen take effect after a restart.")
+			}
+
+			newUpdateInterval := time.Duration(cfg.GeneralSettings.UpdateInterval) * time.Second
+			if newUpdateInterval != updateInterval {
+				updateInterval = newUpdateInterval
+				if !updateTimer.Stop() {
+					select {
+					case <-updateTimer.C:
+					default:
+					}
+				}
+				updateTimer.Reset(updateInterval)
+				status.SetNextCheck(time.Now().Add(updateInterval))
+			}
+			newConnectivityCheckInterval := time.Duration(cfg.GeneralSettings.ConnectivityCheckInterval) * time.Second
+			if newConnectivityCheckInterval != connectivityCheckInterval {
+				connectivityCheckInterval = newConnectivityCheckInterval
+				connectivityTicker.Reset(connectivityCheckInterval)
+			}
+
+			// Reconcile new or changed records right away rather than waiting for an IP change
+			if isConnected {
+				diff := changedRecords(oldCfg, cfg)
+				if len(diff.Providers) > 0 {
+					logrus.Info("Configuration changed. Updating new or changed DNS records.")
+					runOnce(diff, notifier)
+				} else {
+					logrus.Debug("No new or changed DNS records after reload.")
+				}
+			}
+		case <-mqttCommands:
+			logrus.Info("Updating DNS records on request.")
+			runOnce(cfg, notifier)
 		case <-done:
 			logrus.Info("Service stopped.")
 			return
 		}
+
+		syncMQTT()
+	}
+}
+
+// notifyIPChange logs and emits an IP change notification when a newly
+// detected address differs from the previous one.
+func notifyIPChange(notifier *notify.Dispatcher, family, oldIP, newIP string) {
+	if newIP != "" && newIP != oldIP {
+		logrus.WithFields(logrus.Fields{
+			"family": family,
+			"old":    oldIP,
+			"new":    newIP,
+			"action": "ip_changed",
+		}).Infof("External %s address changed: %s -> %s", family, oldIP, newIP)
+		notifier.IPChanged(family, oldIP, newIP)
 	}
 }
 
@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	detectedIP = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cfddns_detected_ip_info",
+		Help: "Last detected external IP address per family, always 1.",
+	}, []string{"family", "ip"})
+
+	recordLastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cfddns_record_last_success_timestamp_seconds",
+		Help: "Unix time of the last successful commit of a DNS record.",
+	}, []string{"provider", "record", "type"})
+
+	updates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cfddns_updates_total",
+		Help: "Number of DNS record commits per provider and result.",
+	}, []string{"provider", "result"})
+
+	commitDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cfddns_commit_duration_seconds",
+		Help:    "Time taken by a provider to commit a DNS record.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"provider"})
+
+	ipFetchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cfddns_ip_fetch_duration_seconds",
+		Help:    "Time taken by an IP detection service to respond.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 8),
+	}, []string{"service", "family", "result"})
+
+	notificationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cfddns_notification_failures_total",
+		Help: "Number of notifications and hooks that failed or were dropped because their queue was full.",
+	}, []string{"notifier", "reason"})
+
+	connected = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cfddns_connected",
+		Help: "Whether the daemon considers the internet connection available (1) or not (0).",
+	})
+)
+
+// Handler returns the HTTP handler serving the metrics in Prometheus format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// SetDetectedIP records the latest external IP for family ("ipv4" or "ipv6").
+// An empty ip clears the family.
+func SetDetectedIP(family, ip string) {
+	detectedIP.DeletePartialMatch(prometheus.Labels{"family": family})
+	if ip != "" {
+		detectedIP.WithLabelValues(family, ip).Set(1)
+	}
+}
+
+// ObserveCommit records the outcome and duration of a CommitRecord call.
+func ObserveCommit(provider, record, recordType string, duration time.Duration, err error) {
+	commitDuration.WithLabelValues(provider).Observe(duration.Seconds())
+	if err != nil {
+		updates.WithLabelValues(provider, "failure").Inc()
+		return
+	}
+	updates.WithLabelValues(provider, "success").Inc()
+	recordLastSuccess.WithLabelValues(provider, record, recordType).SetToCurrentTime()
+}
+
+// DeleteRecord drops the series of a record that is no longer configured.
+func DeleteRecord(provider, record, recordType string) {
+	recordLastSuccess.DeleteLabelValues(provider, record, recordType)
+}
+
+// ObserveIPFetch records the duration of a request to an IP detection service.
+func ObserveIPFetch(service, family string, duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	ipFetchDuration.WithLabelValues(service, family, result).Observe(duration.Seconds())
+}
+
+// NotificationFailed records a notification that failed ("error") or was
+// dropped ("dropped").
+func NotificationFailed(notifier, reason string) {
+	notificationFailures.WithLabelValues(notifier, reason).Inc()
+}
+
+// SetConnected records the connectivity state tracked by the daemon.
+func SetConnected(isConnected bool) {
+	if isConnected {
+		connected.Set(1)
+	} else {
+		connected.Set(0)
+	}
+}
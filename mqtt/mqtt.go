@@ -0,0 +1,292 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"cfddns/config"
+	"cfddns/status"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	connectTimeout = 10 * time.Second
+	publishTimeout = 10 * time.Second
+
+	payloadOnline       = "online"
+	payloadOffline      = "offline"
+	payloadConnected    = "connected"
+	payloadDisconnected = "disconnected"
+	commandUpdate       = "update"
+)
+
+var unsafeTopicChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// Publisher mirrors the daemon's status to retained MQTT topics under
+// topicPrefix, optionally announces them through Home Assistant MQTT
+// discovery, and listens on <topicPrefix>/command for update requests.
+type Publisher struct {
+	cfg      config.MQTTConfig
+	client   paho.Client
+	commands chan struct{}
+
+	mu         sync.Mutex
+	published  map[string]string
+	discovered map[string]bool
+}
+
+func New(cfg config.MQTTConfig) (*Publisher, error) {
+	p := &Publisher{
+		cfg:        cfg,
+		commands:   make(chan struct{}, 1),
+		published:  make(map[string]string),
+		discovered: make(map[string]bool),
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectTimeout(connectTimeout).
+		SetWill(p.topic("availability"), payloadOffline, 1, true).
+		SetOnConnectHandler(p.onConnect).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			logrus.Warnf("MQTT connection lost: %v", err)
+		})
+
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	// With connect retry enabled the client keeps trying in the background, so
+	// an unreachable broker at startup does not stop DNS updates.
+	p.client = paho.NewClient(opts)
+	token := p.client.Connect()
+	if !token.WaitTimeout(connectTimeout) {
+		logrus.Warnf("MQTT broker %s is not reachable yet, retrying in the background", cfg.Broker)
+	} else if err := token.Error(); err != nil {
+		p.client.Disconnect(0)
+		return nil, fmt.Errorf("failed to connect to MQTT broker %s: %v", cfg.Broker, err)
+	}
+
+	return p, nil
+}
+
+func newTLSConfig(cfg config.MQTTConfig) (*tls.Config, error) {
+	if cfg.CAFile == "" && cfg.CertFile == "" && !cfg.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+
+	if cfg.CAFile != "" {
+		caPEM, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MQTT caFile: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in MQTT caFile %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load MQTT client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// onConnect runs on every (re)connect: it resubscribes to the command topic
+// and forgets what was published so the next Sync republishes everything.
+func (p *Publisher) onConnect(client paho.Client) {
+	logrus.Infof("Connected to MQTT broker %s", p.cfg.Broker)
+
+	p.mu.Lock()
+	p.published = make(map[string]string)
+	p.discovered = make(map[string]bool)
+	p.mu.Unlock()
+
+	client.Publish(p.topic("availability"), 1, true, payloadOnline)
+
+	client.Subscribe(p.topic("command"), 1, func(_ paho.Client, msg paho.Message) {
+		payload := strings.TrimSpace(string(msg.Payload()))
+		if !strings.EqualFold(payload, commandUpdate) {
+			logrus.Warnf("Ignoring unknown MQTT command: %q", payload)
+			return
+		}
+		logrus.Info("Update requested over MQTT.")
+		select {
+		case p.commands <- struct{}{}:
+		default:
+		}
+	})
+
+	if p.cfg.Discovery {
+		p.publishDiscovery("button", "update", map[string]interface{}{
+			"name":          "Update DNS records",
+			"command_topic": p.topic("command"),
+			"payload_press": commandUpdate,
+		})
+	}
+}
+
+// Commands delivers a value whenever an update is requested on the command topic.
+func (p *Publisher) Commands() <-chan struct{} {
+	return p.commands
+}
+
+// Sync publishes the parts of snapshot that changed since the last call.
+func (p *Publisher) Sync(snapshot status.Status) {
+	connectivity := payloadDisconnected
+	if snapshot.Connected {
+		connectivity = payloadConnected
+	}
+
+	if p.cfg.Discovery {
+		p.publishDiscovery("sensor", "ipv4", map[string]interface{}{
+			"name":        "IPv4 address",
+			"state_topic": p.topic("ipv4"),
+			"icon":        "mdi:ip-network",
+		})
+		p.publishDiscovery("sensor", "ipv6", map[string]interface{}{
+			"name":        "IPv6 address",
+			"state_topic": p.topic("ipv6"),
+			"icon":        "mdi:ip-network",
+		})
+		p.publishDiscovery("binary_sensor", "connectivity", map[string]interface{}{
+			"name":         "Internet connectivity",
+			"state_topic":  p.topic("connectivity"),
+			"payload_on":   payloadConnected,
+			"payload_off":  payloadDisconnected,
+			"device_class": "connectivity",
+		})
+	}
+
+	p.publish(p.topic("ipv4"), snapshot.IPv4)
+	p.publish(p.topic("ipv6"), snapshot.IPv6)
+	p.publish(p.topic("connectivity"), connectivity)
+
+	for _, record := range snapshot.Records {
+		slug := recordSlug(record)
+		stateTopic := p.topic("record/" + slug + "/state")
+		attributesTopic := p.topic("record/" + slug + "/attributes")
+
+		if p.cfg.Discovery {
+			p.publishDiscovery("sensor", "record_"+slug, map[string]interface{}{
+				"name":                  fmt.Sprintf("%s %s (%s)", record.Provider, record.Name, record.Type),
+				"state_topic":           stateTopic,
+				"json_attributes_topic": attributesTopic,
+				"icon":                  "mdi:dns",
+			})
+		}
+
+		attributes, err := json.Marshal(record)
+		if err != nil {
+			logrus.Warnf("Error encoding MQTT record attributes: %v", err)
+			continue
+		}
+		p.publish(stateTopic, record.Value)
+		p.publish(attributesTopic, string(attributes))
+	}
+}
+
+// Close marks cfddns offline and disconnects from the broker.
+func (p *Publisher) Close() {
+	if p.client.IsConnectionOpen() {
+		token := p.client.Publish(p.topic("availability"), 1, true, payloadOffline)
+		token.WaitTimeout(publishTimeout)
+	}
+	p.client.Disconnect(250)
+}
+
+func (p *Publisher) topic(suffix string) string {
+	return p.cfg.TopicPrefix + "/" + suffix
+}
+
+// publish sends a retained message unless the same payload was already
+// published to topic. While disconnected nothing is sent; onConnect resets
+// the cache so the next Sync catches up.
+func (p *Publisher) publish(topic, payload string) {
+	if !p.client.IsConnectionOpen() {
+		return
+	}
+
+	p.mu.Lock()
+	if last, ok := p.published[topic]; ok && last == payload {
+		p.mu.Unlock()
+		return
+	}
+	p.published[topic] = payload
+	p.mu.Unlock()
+
+	token := p.client.Publish(topic, 1, true, payload)
+	if token.WaitTimeout(publishTimeout) && token.Error() != nil {
+		logrus.Warnf("Error publishing to MQTT topic %s: %v", topic, token.Error())
+		p.mu.Lock()
+		delete(p.published, topic)
+		p.mu.Unlock()
+	}
+}
+
+// publishDiscovery announces an entity through Home Assistant MQTT discovery.
+func (p *Publisher) publishDiscovery(component, objectID string, entity map[string]interface{}) {
+	if !p.client.IsConnectionOpen() {
+		return
+	}
+
+	nodeID := unsafeTopicChars.ReplaceAllString(p.cfg.ClientID, "_")
+	uniqueID := nodeID + "_" + objectID
+
+	p.mu.Lock()
+	if p.discovered[uniqueID] {
+		p.mu.Unlock()
+		return
+	}
+	p.discovered[uniqueID] = true
+	p.mu.Unlock()
+
+	entity["unique_id"] = uniqueID
+	entity["object_id"] = uniqueID
+	entity["availability_topic"] = p.topic("availability")
+	entity["device"] = map[string]interface{}{
+		"identifiers": []string{nodeID},
+		"name":        "cfddns (" + p.cfg.ClientID + ")",
+		"model":       "cfddns",
+	}
+
+	payload, err := json.Marshal(entity)
+	if err != nil {
+		logrus.Warnf("Error encoding MQTT discovery config: %v", err)
+		return
+	}
+
+	topic := fmt.Sprintf("%s/%s/%s/%s/config", p.cfg.DiscoveryPrefix, component, nodeID, objectID)
+	p.client.Publish(topic, 1, true, payload)
+}
+
+func recordSlug(record status.RecordStatus) string {
+	slug := strings.ToLower(record.Provider + "_" + record.Name + "_" + record.Type)
+	return strings.Trim(unsafeTopicChars.ReplaceAllString(slug, "_"), "_")
+}
@@ -0,0 +1,179 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cfddns/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+const smtpDialTimeout = 30 * time.Second
+
+// Email sends a summary of the events collected during each batch window,
+// so a flapping link produces one message rather than one per event.
+type Email struct {
+	Host     string
+	Port     int
+	Security string
+	Username string
+	Password string
+	From     string
+	To       []string
+
+	window time.Duration
+	// rootCAs verifies the server certificate instead of the system roots
+	rootCAs *x509.CertPool
+
+	mu      sync.Mutex
+	pending []Event
+	timer   *time.Timer
+}
+
+func NewEmail(cfg config.EmailConfig) *Email {
+	return &Email{
+		Host:     cfg.Host,
+		Port:     cfg.Port,
+		Security: cfg.Security,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		From:     cfg.From,
+		To:       cfg.To,
+		window:   time.Duration(cfg.BatchWindow) * time.Second,
+	}
+}
+
+func (e *Email) Name() string {
+	return "email " + strings.Join(e.To, ",")
+}
+
+// Notify queues the event and starts the batch window if it is not running.
+func (e *Email) Notify(event Event) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.pending = append(e.pending, event)
+	if e.timer == nil {
+		e.timer = time.AfterFunc(e.window, func() {
+			if err := e.Flush(); err != nil {
+				logrus.Errorf("Error sending notification via %s: %v", e.Name(), err)
+			}
+		})
+	}
+	return nil
+}
+
+// Flush sends any queued events immediately.
+func (e *Email) Flush() error {
+	e.mu.Lock()
+	events := e.pending
+	e.pending = nil
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+	e.mu.Unlock()
+
+	if len(events) == 0 {
+		return nil
+	}
+	return e.send(events)
+}
+
+func (e *Email) send(events []Event) error {
+	address := net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	tlsConfig := &tls.Config{ServerName: e.Host, RootCAs: e.rootCAs}
+
+	var conn net.Conn
+	var err error
+	if e.Security == "tls" {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: smtpDialTimeout}, "tcp", address, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", address, smtpDialTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %v", err)
+	}
+
+	client, err := smtp.NewClient(conn, e.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %v", err)
+	}
+	defer client.Close()
+
+	if e.Security == "starttls" {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("failed to start TLS: %v", err)
+		}
+	}
+
+	if e.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.Username, e.Password, e.Host)); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %v", err)
+		}
+	}
+
+	if err := client.Mail(e.From); err != nil {
+		return fmt.Errorf("SMTP MAIL FROM failed: %v", err)
+	}
+	for _, to := range e.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("SMTP RCPT TO %s failed: %v", to, err)
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP DATA failed: %v", err)
+	}
+	if _, err := writer.Write(e.message(events)); err != nil {
+		return fmt.Errorf("failed to write message: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %v", err)
+	}
+
+	return client.Quit()
+}
+
+func (e *Email) message(events []Event) []byte {
+	subject := "cfddns: " + singleLine(events[0].Message)
+	if len(events) > 1 {
+		subject = fmt.Sprintf("cfddns: %d events", len(events))
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", e.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	for _, event := range events {
+		// Messages may carry multi-line provider responses; continuation
+		// lines are indented under the first
+		lines := strings.Split(strings.ReplaceAll(event.Message, "\r\n", "\n"), "\n")
+		for i, line := range lines {
+			lines[i] = strings.ReplaceAll(line, "\r", " ")
+		}
+		fmt.Fprintf(&buf, "%s  %s\r\n", event.Time.Format(time.RFC3339), strings.Join(lines, "\r\n    "))
+	}
+	return buf.Bytes()
+}
+
+// singleLine collapses runs of whitespace, including line breaks that
+// would end a header, into single spaces.
+func singleLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
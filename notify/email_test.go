@@ -0,0 +1,351 @@
+package notify
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpServer is a minimal SMTP stand-in that records the messages it
+// receives.
+type smtpServer struct {
+	t         *testing.T
+	listener  net.Listener
+	tlsConfig *tls.Config // offers STARTTLS when set
+	username  string
+	password  string
+
+	mu       sync.Mutex
+	messages []string
+	authed   []string
+	usedTLS  []bool
+}
+
+func newSMTPServer(t *testing.T, tlsConfig *tls.Config, username, password string) *smtpServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &smtpServer{t: t, listener: listener, tlsConfig: tlsConfig, username: username, password: password}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpServer) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	secure := false
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO", "HELO":
+			var extensions []string
+			if s.tlsConfig != nil && !secure {
+				extensions = append(extensions, "STARTTLS")
+			}
+			if s.username != "" {
+				extensions = append(extensions, "AUTH PLAIN")
+			}
+			if len(extensions) == 0 {
+				reply("250 localhost")
+				continue
+			}
+			reply("250-localhost")
+			for i, extension := range extensions {
+				if i == len(extensions)-1 {
+					reply("250 " + extension)
+				} else {
+					reply("250-" + extension)
+				}
+			}
+		case "STARTTLS":
+			if s.tlsConfig == nil {
+				reply("502 5.5.1 STARTTLS not supported")
+				continue
+			}
+			reply("220 2.0.0 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			reader = bufio.NewReader(conn)
+			secure = true
+		case "AUTH":
+			fields := strings.Fields(line)
+			credentials, _ := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+			parts := strings.Split(string(credentials), "\x00")
+			if len(parts) != 3 || parts[1] != s.username || parts[2] != s.password {
+				reply("535 5.7.8 Authentication credentials invalid")
+				continue
+			}
+			s.mu.Lock()
+			s.authed = append(s.authed, parts[1])
+			s.mu.Unlock()
+			reply("235 2.7.0 Authentication successful")
+		case "MAIL", "RCPT", "RSET", "NOOP":
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, data.String())
+			s.usedTLS = append(s.usedTLS, secure)
+			s.mu.Unlock()
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("500 unknown command")
+		}
+	}
+}
+
+func (s *smtpServer) received() ([]string, []string, []bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...), append([]string(nil), s.authed...), append([]bool(nil), s.usedTLS...)
+}
+
+// testCertificate returns a self-signed certificate for 127.0.0.1 and a pool
+// that trusts it.
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func newTestEmail(port int, security string) *Email {
+	return &Email{
+		Host:     "127.0.0.1",
+		Port:     port,
+		Security: security,
+		From:     "cfddns@example.com",
+		To:       []string{"admin@example.com"},
+		window:   100 * time.Millisecond,
+	}
+}
+
+func TestEmailBatchesEventsWithinWindow(t *testing.T) {
+	server := newSMTPServer(t, nil, "", "")
+	email := newTestEmail(server.port(), "none")
+
+	for _, message := range []string{"first", "second", "third"} {
+		if err := email.Notify(Event{Type: EventIPChanged, Time: time.Now(), Message: message}); err != nil {
+			t.Fatalf("Notify: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	var messages []string
+	for time.Now().Before(deadline) {
+		if messages, _, _ = server.received(); len(messages) > 0 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	// Give a stray second message the chance to arrive
+	time.Sleep(200 * time.Millisecond)
+	messages, _, _ = server.received()
+
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	if !strings.Contains(messages[0], "Subject: cfddns: 3 events\r\n") {
+		t.Errorf("message lacks the batch subject:\n%s", messages[0])
+	}
+	for _, message := range []string{"first", "second", "third"} {
+		if !strings.Contains(messages[0], message) {
+			t.Errorf("message lacks event %q", message)
+		}
+	}
+}
+
+func TestEmailFlushSendsImmediately(t *testing.T) {
+	server := newSMTPServer(t, nil, "", "")
+	email := newTestEmail(server.port(), "none")
+	email.window = time.Hour
+
+	email.Notify(Event{Type: EventIPChanged, Time: time.Now(), Message: "changed"})
+	if err := email.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if messages, _, _ := server.received(); len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	if err := email.Flush(); err != nil {
+		t.Fatalf("second Flush: %v", err)
+	}
+	if messages, _, _ := server.received(); len(messages) != 1 {
+		t.Errorf("empty Flush sent a message")
+	}
+}
+
+func TestEmailStartTLS(t *testing.T) {
+	cert, pool := testCertificate(t)
+
+	t.Run("required", func(t *testing.T) {
+		server := newSMTPServer(t, &tls.Config{Certificates: []tls.Certificate{cert}}, "", "")
+		email := newTestEmail(server.port(), "starttls")
+		email.rootCAs = pool
+
+		if err := email.send([]Event{{Time: time.Now(), Message: "secure"}}); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+		_, _, usedTLS := server.received()
+		if len(usedTLS) != 1 || !usedTLS[0] {
+			t.Errorf("message was not sent over TLS: %v", usedTLS)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		server := newSMTPServer(t, nil, "", "")
+		email := newTestEmail(server.port(), "starttls")
+
+		err := email.send([]Event{{Time: time.Now(), Message: "secure"}})
+		if err == nil || !strings.Contains(err.Error(), "failed to start TLS") {
+			t.Fatalf("send = %v, want a STARTTLS error", err)
+		}
+		if messages, _, _ := server.received(); len(messages) != 0 {
+			t.Errorf("message was sent without TLS")
+		}
+	})
+
+	t.Run("untrusted certificate", func(t *testing.T) {
+		server := newSMTPServer(t, &tls.Config{Certificates: []tls.Certificate{cert}}, "", "")
+		email := newTestEmail(server.port(), "starttls")
+
+		if err := email.send([]Event{{Time: time.Now(), Message: "secure"}}); err == nil {
+			t.Fatal("send succeeded with an untrusted certificate")
+		}
+	})
+}
+
+func TestEmailAuth(t *testing.T) {
+	cert, pool := testCertificate(t)
+	tests := []struct {
+		name     string
+		password string
+		wantErr  bool
+	}{
+		{"valid credentials", "secret", false},
+		{"invalid credentials", "wrong", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSMTPServer(t, &tls.Config{Certificates: []tls.Certificate{cert}}, "user", "secret")
+			email := newTestEmail(server.port(), "starttls")
+			email.rootCAs = pool
+			email.Username = "user"
+			email.Password = tt.password
+
+			err := email.send([]Event{{Time: time.Now(), Message: "auth"}})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("send = %v, wantErr %v", err, tt.wantErr)
+			}
+			messages, authed, _ := server.received()
+			if tt.wantErr {
+				if !strings.Contains(err.Error(), "SMTP authentication failed") {
+					t.Errorf("send = %v, want an authentication error", err)
+				}
+				if len(messages) != 0 {
+					t.Errorf("message was sent without authentication")
+				}
+				return
+			}
+			if len(authed) != 1 || authed[0] != "user" {
+				t.Errorf("authenticated as %v, want user", authed)
+			}
+		})
+	}
+}
+
+func TestEmailMessageFoldsLineBreaks(t *testing.T) {
+	email := newTestEmail(25, "none")
+	failure := "Failed to update home.example.com: response: badauth\r\nX-Injected: yes\nmore"
+
+	message := string(email.message([]Event{{Time: time.Now(), Message: failure}}))
+	header, body, _ := strings.Cut(message, "\r\n\r\n")
+
+	if strings.Contains(header, "\nX-Injected") {
+		t.Errorf("subject broke the header:\n%s", header)
+	}
+	if !strings.Contains(header, "Subject: cfddns: Failed to update home.example.com: response: badauth X-Injected: yes more\r\n") {
+		t.Errorf("unexpected subject in:\n%s", header)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\r\n"), "\r\n") {
+		if strings.ContainsAny(line, "\r\n") {
+			t.Errorf("body line %q has a bare line break", line)
+		}
+	}
+	if !strings.Contains(body, "\r\n    X-Injected: yes\r\n    more\r\n") {
+		t.Errorf("continuation lines are not indented:\n%q", body)
+	}
+}
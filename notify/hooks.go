@@ -0,0 +1,121 @@
+package notify
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+
+	"cfddns/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Hooks runs the configured shell commands for events. Details of the event
+// are passed in CFDDNS_* environment variables.
+type Hooks struct {
+	Timeout  time.Duration
+	commands map[EventType]hookCommand
+}
+
+type hookCommand struct {
+	name    string
+	command string
+}
+
+func NewHooks(cfg config.HooksConfig) *Hooks {
+	hooks := &Hooks{
+		Timeout:  time.Duration(cfg.Timeout) * time.Second,
+		commands: make(map[EventType]hookCommand),
+	}
+	if cfg.OnIPChange != "" {
+		hooks.commands[EventIPChanged] = hookCommand{"onIPChange", cfg.OnIPChange}
+	}
+	if cfg.OnRecordUpdated != "" {
+		hooks.commands[EventRecordCreated] = hookCommand{"onRecordUpdated", cfg.OnRecordUpdated}
+		hooks.commands[EventRecordUpdated] = hookCommand{"onRecordUpdated", cfg.OnRecordUpdated}
+	}
+	if cfg.OnFailure != "" {
+		hooks.commands[EventRecordFailed] = hookCommand{"onFailure", cfg.OnFailure}
+	}
+	return hooks
+}
+
+// Events returns the events that have a hook configured.
+func (h *Hooks) Events() []string {
+	var events []string
+	for eventType := range h.commands {
+		events = append(events, string(eventType))
+	}
+	return events
+}
+
+// Inline runs the onIPChange hook before the caller goes on to update the
+// records.
+func (h *Hooks) Inline(eventType EventType) bool {
+	return eventType == EventIPChanged
+}
+
+func (h *Hooks) Name() string {
+	return "hooks"
+}
+
+func (h *Hooks) Notify(event Event) error {
+	hook, ok := h.commands[event.Type]
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.Timeout)
+	defer cancel()
+
+	cmd := shellCommand(ctx, hook.command)
+	// Don't wait forever for output from children that outlive the shell
+	cmd.WaitDelay = time.Second
+	cmd.Env = append(os.Environ(),
+		"CFDDNS_EVENT="+string(event.Type),
+		"CFDDNS_MESSAGE="+event.Message,
+		"CFDDNS_FAMILY="+event.Family,
+		"CFDDNS_OLD_IP="+event.OldIP,
+		"CFDDNS_NEW_IP="+event.NewIP,
+		"CFDDNS_PROVIDER="+event.Provider,
+		"CFDDNS_RECORD="+event.Record,
+		"CFDDNS_RECORD_TYPE="+event.RecordType,
+		"CFDDNS_ERROR="+event.Error,
+		"CFDDNS_FAILURES="+strconv.Itoa(event.Failures),
+	)
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	logrus.Debugf("Running %s hook: %s", hook.name, hook.command)
+	start := time.Now()
+	err := cmd.Run()
+
+	scanner := bufio.NewScanner(&output)
+	for scanner.Scan() {
+		logrus.Infof("%s hook: %s", hook.name, scanner.Text())
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s hook timed out after %s", hook.name, h.Timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook failed: %v", hook.name, err)
+	}
+	logrus.Debugf("%s hook finished in %s", hook.name, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "/bin/sh", "-c", command)
+}
@@ -0,0 +1,35 @@
+package notify
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"cfddns/config"
+)
+
+func TestIPChangeHookRunsBeforeReturning(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook command uses sh")
+	}
+	marker := filepath.Join(t.TempDir(), "ran")
+	d, err := New(config.NotificationSettings{}, config.HooksConfig{
+		Timeout:    5,
+		OnIPChange: `sleep 0.2; echo "$CFDDNS_NEW_IP" > "` + marker + `"`,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer d.Close()
+
+	d.IPChanged("ipv4", "203.0.113.1", "203.0.113.2")
+
+	content, err := os.ReadFile(marker)
+	if err != nil {
+		t.Fatalf("onIPChange hook had not run when IPChanged returned: %v", err)
+	}
+	if string(content) != "203.0.113.2\n" {
+		t.Errorf("hook wrote %q, want the new address", content)
+	}
+}
@@ -0,0 +1,288 @@
+package notify
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"cfddns/config"
+	"cfddns/metrics"
+	"cfddns/providers"
+
+	"github.com/sirupsen/logrus"
+)
+
+type EventType string
+
+const (
+	EventIPChanged        EventType = "ip_changed"
+	EventRecordCreated    EventType = "record_created"
+	EventRecordUpdated    EventType = "record_updated"
+	EventRecordFailed     EventType = "record_failed"
+	EventProviderDisabled EventType = "provider_disabled"
+)
+
+var eventTypes = []EventType{
+	EventIPChanged,
+	EventRecordCreated,
+	EventRecordUpdated,
+	EventRecordFailed,
+	EventProviderDisabled,
+}
+
+type Event struct {
+	Type       EventType `json:"event"`
+	Time       time.Time `json:"time"`
+	Message    string    `json:"message"`
+	Family     string    `json:"family,omitempty"`
+	OldIP      string    `json:"oldIp,omitempty"`
+	NewIP      string    `json:"newIp,omitempty"`
+	Provider   string    `json:"provider,omitempty"`
+	Record     string    `json:"record,omitempty"`
+	RecordType string    `json:"recordType,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Failures   int       `json:"failures,omitempty"`
+}
+
+type Notifier interface {
+	Name() string
+	Notify(event Event) error
+}
+
+// Flusher is implemented by notifiers that batch events. Flush is called
+// when the dispatcher is closed so nothing queued is lost.
+type Flusher interface {
+	Flush() error
+}
+
+// Inliner is implemented by notifiers that must handle some events before
+// the caller carries on, such as the onIPChange hook that has to run before
+// the records are updated. Those events bypass the queue.
+type Inliner interface {
+	Inline(eventType EventType) bool
+}
+
+// queueSize bounds how many events can wait for a slow notifier before new
+// ones are dropped, so a stuck endpoint never blocks DNS updates.
+const queueSize = 64
+
+type subscription struct {
+	notifier Notifier
+	events   map[EventType]bool
+	queue    chan Event
+}
+
+// Dispatcher fans events out to the configured notifiers. Each notifier has
+// its own queue and goroutine, so delivery is ordered per notifier and never
+// blocks the caller.
+type Dispatcher struct {
+	subscriptions    []*subscription
+	failureThreshold int
+
+	mu       sync.Mutex
+	failures map[string]int
+	wg       sync.WaitGroup
+}
+
+// New builds a Dispatcher for the notifications and hooks sections of the
+// config.
+func New(settings config.NotificationSettings, hooksCfg config.HooksConfig) (*Dispatcher, error) {
+	d := &Dispatcher{
+		failureThreshold: settings.FailureThreshold,
+		failures:         make(map[string]int),
+	}
+
+	// Subscriptions made before an error are closed again so their goroutines
+	// don't outlive the failed reload
+	for _, webhookCfg := range settings.Webhooks {
+		webhook, err := NewWebhook(webhookCfg)
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		if err := d.subscribe(webhook, webhookCfg.Events); err != nil {
+			d.Close()
+			return nil, err
+		}
+	}
+
+	if settings.Email != nil {
+		if err := d.subscribe(NewEmail(*settings.Email), settings.Email.Events); err != nil {
+			d.Close()
+			return nil, err
+		}
+	}
+
+	if hooks := NewHooks(hooksCfg); len(hooks.Events()) > 0 {
+		if err := d.subscribe(hooks, hooks.Events()); err != nil {
+			d.Close()
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+func (d *Dispatcher) subscribe(notifier Notifier, events []string) error {
+	sub := &subscription{
+		notifier: notifier,
+		events:   make(map[EventType]bool),
+		queue:    make(chan Event, queueSize),
+	}
+
+	if len(events) == 0 {
+		for _, eventType := range eventTypes {
+			sub.events[eventType] = true
+		}
+	}
+	for _, name := range events {
+		if !isEventType(EventType(name)) {
+			return fmt.Errorf("%s: unknown notification event: %s", notifier.Name(), name)
+		}
+		sub.events[EventType(name)] = true
+	}
+
+	d.subscriptions = append(d.subscriptions, sub)
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for event := range sub.queue {
+			deliver(sub.notifier, event)
+		}
+		if flusher, ok := sub.notifier.(Flusher); ok {
+			if err := flusher.Flush(); err != nil {
+				logrus.Errorf("Error sending notification via %s: %v", sub.notifier.Name(), err)
+			}
+		}
+	}()
+	return nil
+}
+
+func isEventType(eventType EventType) bool {
+	for _, known := range eventTypes {
+		if known == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Close stops accepting events and waits for queued ones to be delivered.
+func (d *Dispatcher) Close() {
+	for _, sub := range d.subscriptions {
+		close(sub.queue)
+	}
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) emit(event Event) {
+	event.Time = time.Now()
+	for _, sub := range d.subscriptions {
+		if !sub.events[event.Type] {
+			continue
+		}
+		if inliner, ok := sub.notifier.(Inliner); ok && inliner.Inline(event.Type) {
+			deliver(sub.notifier, event)
+			continue
+		}
+		select {
+		case sub.queue <- event:
+		default:
+			metrics.NotificationFailed(sub.notifier.Name(), "dropped")
+			logrus.Errorf("Dropping %s notification for %s: queue is full", event.Type, sub.notifier.Name())
+		}
+	}
+}
+
+func deliver(notifier Notifier, event Event) {
+	if err := notifier.Notify(event); err != nil {
+		metrics.NotificationFailed(notifier.Name(), "error")
+		logrus.Errorf("Error sending %s notification via %s: %v", event.Type, notifier.Name(), err)
+	}
+}
+
+// IPChanged reports that the detected address for family changed.
+func (d *Dispatcher) IPChanged(family, oldIP, newIP string) {
+	d.emit(Event{
+		Type:    EventIPChanged,
+		Message: fmt.Sprintf("External %s address changed from %s to %s", family, displayIP(oldIP), displayIP(newIP)),
+		Family:  family,
+		OldIP:   oldIP,
+		NewIP:   newIP,
+	})
+}
+
+// RecordCommitted reports a successful CommitRecord and resets the record's
+// failure count. Unchanged records produce no event.
+func (d *Dispatcher) RecordCommitted(provider string, record providers.DNSRecord, action providers.Action) {
+	d.mu.Lock()
+	delete(d.failures, failureKey(provider, record))
+	d.mu.Unlock()
+
+	var eventType EventType
+	switch action {
+	case providers.ActionCreated:
+		eventType = EventRecordCreated
+	case providers.ActionUpdated:
+		eventType = EventRecordUpdated
+	default:
+		return
+	}
+
+	d.emit(Event{
+		Type:       eventType,
+		Message:    fmt.Sprintf("%s record %s (%s) %s -> %s", provider, record.Name, record.Type, action, record.Content),
+		NewIP:      record.Content,
+		Provider:   provider,
+		Record:     record.Name,
+		RecordType: record.Type,
+	})
+}
+
+// RecordFailed reports a failed CommitRecord. An event is emitted once the
+// record has failed failureThreshold times in a row.
+func (d *Dispatcher) RecordFailed(provider string, record providers.DNSRecord, err error) {
+	d.mu.Lock()
+	key := failureKey(provider, record)
+	d.failures[key]++
+	failures := d.failures[key]
+	d.mu.Unlock()
+
+	if failures != d.failureThreshold {
+		return
+	}
+
+	d.emit(Event{
+		Type:       EventRecordFailed,
+		Message:    fmt.Sprintf("%s record %s (%s) failed to update %d times in a row: %v", provider, record.Name, record.Type, failures, err),
+		NewIP:      record.Content,
+		Provider:   provider,
+		Record:     record.Name,
+		RecordType: record.Type,
+		Error:      err.Error(),
+		Failures:   failures,
+	})
+}
+
+// ProviderDisabled reports that provider is no longer called after failing
+// permanently failures times in a row, until retryIn has passed.
+func (d *Dispatcher) ProviderDisabled(provider string, err error, failures int, retryIn time.Duration) {
+	d.emit(Event{
+		Type:     EventProviderDisabled,
+		Message:  fmt.Sprintf("%s provider disabled after %d permanent failures in a row, retrying in %s: %v", provider, failures, retryIn, err),
+		Provider: provider,
+		Error:    err.Error(),
+		Failures: failures,
+	})
+}
+
+func failureKey(provider string, record providers.DNSRecord) string {
+	return provider + "/" + record.Name + "/" + record.Type
+}
+
+func displayIP(ip string) string {
+	if ip == "" {
+		return "none"
+	}
+	return ip
+}
@@ -0,0 +1,31 @@
+package notify
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"cfddns/config"
+)
+
+func TestNewClosesSubscriptionsOnError(t *testing.T) {
+	settings := config.NotificationSettings{
+		Webhooks: []config.WebhookConfig{{URL: "http://127.0.0.1/hook"}},
+		Email:    &config.EmailConfig{Host: "127.0.0.1", Events: []string{"bogus"}},
+	}
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		if _, err := New(settings, config.HooksConfig{}); err == nil {
+			t.Fatal("New succeeded with an unknown event")
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("%d goroutines leaked after failed New calls", after-before)
+	}
+}
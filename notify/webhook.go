@@ -0,0 +1,106 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"text/template"
+	"time"
+
+	"cfddns/config"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the request body when a
+// webhook has a secret configured.
+const SignatureHeader = "X-Cfddns-Signature"
+
+type Webhook struct {
+	URL     string
+	Headers map[string]string
+	Secret  string
+
+	body   *template.Template
+	client *http.Client
+}
+
+var templateFuncs = template.FuncMap{
+	// json renders a value as a JSON literal, e.g. {"text": {{json .Message}}}
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func NewWebhook(cfg config.WebhookConfig) (*Webhook, error) {
+	webhook := &Webhook{
+		URL:     cfg.URL,
+		Headers: cfg.Headers,
+		Secret:  cfg.Secret,
+		client:  &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+	}
+
+	if cfg.BodyTemplate != "" {
+		body, err := template.New(cfg.URL).Funcs(templateFuncs).Parse(cfg.BodyTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bodyTemplate for webhook %s: %v", cfg.URL, err)
+		}
+		webhook.body = body
+	}
+
+	return webhook, nil
+}
+
+func (w *Webhook) Name() string {
+	return "webhook " + w.URL
+}
+
+func (w *Webhook) Notify(event Event) error {
+	payload, err := w.render(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "cfddns")
+	for name, value := range w.Headers {
+		req.Header.Set(name, value)
+	}
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(payload)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+func (w *Webhook) render(event Event) ([]byte, error) {
+	if w.body == nil {
+		return json.Marshal(event)
+	}
+
+	var buf bytes.Buffer
+	if err := w.body.Execute(&buf, event); err != nil {
+		return nil, fmt.Errorf("failed to render webhook body: %v", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,104 @@
+package providers
+
+import (
+	"sync"
+	"time"
+)
+
+// Breaker stops calling a provider after threshold consecutive permanent
+// failures. While open, one probe is let through once the cool-down has
+// passed. A success closes the breaker again; a permanent failure of the
+// probe doubles the cool-down, up to maxCooldown, and a transient one waits
+// the same cool-down again.
+type Breaker struct {
+	threshold   int
+	cooldown    time.Duration
+	maxCooldown time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	open      bool
+	openUntil time.Time
+	current   time.Duration
+	// probing is set while the probe let through after the cool-down runs
+	probing bool
+}
+
+func NewBreaker(threshold int, cooldown, maxCooldown time.Duration) *Breaker {
+	return &Breaker{
+		threshold:   threshold,
+		cooldown:    cooldown,
+		maxCooldown: maxCooldown,
+	}
+}
+
+// Allow reports whether the provider may be called, and if not, until when
+// it is disabled. Once the cool-down has passed, only the first caller is
+// let through, as the probe.
+func (b *Breaker) Allow() (bool, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.open {
+		return true, time.Time{}
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false, b.openUntil
+	}
+	b.probing = true
+	return true, time.Time{}
+}
+
+// Success records a successful call and reports whether it closed the
+// breaker.
+func (b *Breaker) Success() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasOpen := b.open
+	b.failures = 0
+	b.open = false
+	b.probing = false
+	b.current = 0
+	return wasOpen
+}
+
+// Failure records a failed call. It reports whether the breaker opened
+// because of it, as opposed to staying open after a failed probe, and how
+// long the provider is disabled for.
+func (b *Breaker) Failure(err error) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.open {
+		if !b.probing {
+			// A call let through before the breaker opened
+			return false, b.current
+		}
+		b.probing = false
+		// A probe that failed permanently keeps the breaker open for twice as
+		// long; after a transient failure the probe is simply retried
+		if IsPermanent(err) {
+			b.current *= 2
+			if b.current > b.maxCooldown {
+				b.current = b.maxCooldown
+			}
+		}
+		b.openUntil = time.Now().Add(b.current)
+		return false, b.current
+	}
+
+	if !IsPermanent(err) {
+		b.failures = 0
+		return false, 0
+	}
+	b.failures++
+	if b.failures < b.threshold {
+		return false, 0
+	}
+
+	b.open = true
+	b.current = b.cooldown
+	b.openUntil = time.Now().Add(b.current)
+	return true, b.current
+}
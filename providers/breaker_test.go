@@ -0,0 +1,146 @@
+package providers
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerTransitions(t *testing.T) {
+	permanent := Permanent(errors.New("invalid credentials"))
+	transient := errors.New("connection reset")
+
+	type step struct {
+		op           string // "permanent", "transient", "success", "expire", or "" to only call Allow
+		wantOpened   bool   // Failure opened the breaker
+		wantCooldown time.Duration
+		wantClosed   bool // Success closed the breaker
+		wantAllow    bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "opens after threshold permanent failures",
+			steps: []step{
+				{op: "permanent", wantAllow: true},
+				{op: "permanent", wantAllow: true},
+				{op: "permanent", wantOpened: true, wantCooldown: time.Minute},
+			},
+		},
+		{
+			name: "transient failure resets the streak",
+			steps: []step{
+				{op: "permanent", wantAllow: true},
+				{op: "permanent", wantAllow: true},
+				{op: "transient", wantAllow: true},
+				{op: "permanent", wantAllow: true},
+				{op: "permanent", wantAllow: true},
+				{op: "permanent", wantOpened: true, wantCooldown: time.Minute},
+			},
+		},
+		{
+			name: "success resets the streak",
+			steps: []step{
+				{op: "permanent", wantAllow: true},
+				{op: "permanent", wantAllow: true},
+				{op: "success", wantAllow: true},
+				{op: "permanent", wantAllow: true},
+			},
+		},
+		{
+			name: "probe is allowed once the cooldown passed",
+			steps: []step{
+				{op: "permanent", wantAllow: true},
+				{op: "permanent", wantAllow: true},
+				{op: "permanent", wantOpened: true, wantCooldown: time.Minute},
+				{op: "expire", wantAllow: true},
+			},
+		},
+		{
+			name: "only one probe is let through",
+			steps: []step{
+				{op: "permanent", wantAllow: true},
+				{op: "permanent", wantAllow: true},
+				{op: "permanent", wantOpened: true, wantCooldown: time.Minute},
+				{op: "expire", wantAllow: true},
+				{op: ""},
+				{op: ""},
+			},
+		},
+		{
+			name: "permanent probe failures double the cooldown up to the maximum",
+			steps: []step{
+				{op: "permanent", wantAllow: true},
+				{op: "permanent", wantAllow: true},
+				{op: "permanent", wantOpened: true, wantCooldown: time.Minute},
+				{op: "expire", wantAllow: true},
+				{op: "permanent", wantCooldown: 2 * time.Minute},
+				{op: "expire", wantAllow: true},
+				{op: "permanent", wantCooldown: 4 * time.Minute},
+				{op: "expire", wantAllow: true},
+				{op: "permanent", wantCooldown: 5 * time.Minute},
+				{op: "expire", wantAllow: true},
+				{op: "permanent", wantCooldown: 5 * time.Minute},
+			},
+		},
+		{
+			name: "transient probe failure keeps the cooldown",
+			steps: []step{
+				{op: "permanent", wantAllow: true},
+				{op: "permanent", wantAllow: true},
+				{op: "permanent", wantOpened: true, wantCooldown: time.Minute},
+				{op: "expire", wantAllow: true},
+				{op: "permanent", wantCooldown: 2 * time.Minute},
+				{op: "expire", wantAllow: true},
+				{op: "transient", wantCooldown: 2 * time.Minute},
+				{op: "expire", wantAllow: true},
+				{op: "permanent", wantCooldown: 4 * time.Minute},
+			},
+		},
+		{
+			name: "successful probe closes the breaker",
+			steps: []step{
+				{op: "permanent", wantAllow: true},
+				{op: "permanent", wantAllow: true},
+				{op: "permanent", wantOpened: true, wantCooldown: time.Minute},
+				{op: "expire", wantAllow: true},
+				{op: "success", wantClosed: true, wantAllow: true},
+				{op: "permanent", wantAllow: true},
+				{op: "permanent", wantAllow: true},
+				{op: "permanent", wantOpened: true, wantCooldown: time.Minute},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBreaker(3, time.Minute, 5*time.Minute)
+			for i, s := range tt.steps {
+				switch s.op {
+				case "permanent", "transient":
+					err := permanent
+					if s.op == "transient" {
+						err = transient
+					}
+					opened, cooldown := b.Failure(err)
+					if opened != s.wantOpened || cooldown != s.wantCooldown {
+						t.Fatalf("step %d: Failure() = %v, %v, want %v, %v", i, opened, cooldown, s.wantOpened, s.wantCooldown)
+					}
+				case "success":
+					if closed := b.Success(); closed != s.wantClosed {
+						t.Fatalf("step %d: Success() = %v, want %v", i, closed, s.wantClosed)
+					}
+				case "expire":
+					b.mu.Lock()
+					b.openUntil = time.Now().Add(-time.Second)
+					b.mu.Unlock()
+				}
+				if allowed, _ := b.Allow(); allowed != s.wantAllow {
+					t.Fatalf("step %d: Allow() = %v, want %v", i, allowed, s.wantAllow)
+				}
+			}
+		})
+	}
+}
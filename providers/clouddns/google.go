@@ -2,20 +2,24 @@ package clouddns
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 
 	"cfddns/providers"
 
-	"github.com/sirupsen/logrus"
 	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
+const providerName = "clouddns"
+
 type CloudDNSProvider struct {
 	ProjectID       string
 	CredentialsJSON []byte
 	ZoneName        string
+	Limiter         *providers.Limiter
 }
 
 func (p *CloudDNSProvider) getService() (*dns.Service, error) {
@@ -23,10 +27,21 @@ func (p *CloudDNSProvider) getService() (*dns.Service, error) {
 	return dns.NewService(ctx, option.WithCredentialsJSON(p.CredentialsJSON))
 }
 
-func (p *CloudDNSProvider) CommitRecord(record providers.DNSRecord) error {
+// wrapError adds context to err and marks it permanent when Google rejected
+// the credentials.
+func wrapError(message string, err error) error {
+	wrapped := fmt.Errorf("%s: %v", message, err)
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && providers.IsPermanentStatus(apiErr.Code) {
+		return providers.Permanent(wrapped)
+	}
+	return wrapped
+}
+
+func (p *CloudDNSProvider) CommitRecord(record providers.DNSRecord) (providers.Action, error) {
 	service, err := p.getService()
 	if err != nil {
-		return fmt.Errorf("failed to create Cloud DNS service: %v", err)
+		return "", fmt.Errorf("failed to create Cloud DNS service: %v", err)
 	}
 
 	ctx := context.Background()
@@ -39,9 +54,12 @@ func (p *CloudDNSProvider) CommitRecord(record providers.DNSRecord) error {
 	recListCall := service.ResourceRecordSets.List(p.ProjectID, p.ZoneName)
 	recListCall.Name(fqdn)
 	recListCall.Type(record.Type)
+	if err := p.Limiter.Wait(); err != nil {
+		return "", err
+	}
 	recList, err := recListCall.Context(ctx).Do()
 	if err != nil {
-		return fmt.Errorf("failed to list DNS records: %v", err)
+		return "", wrapError("failed to list DNS records", err)
 	}
 
 	// Prepare the change
@@ -54,20 +72,25 @@ func (p *CloudDNSProvider) CommitRecord(record providers.DNSRecord) error {
 		Rrdatas: []string{rrdata},
 	}
 
+	action := providers.ActionCreated
 	change := &dns.Change{}
 	if len(recList.Rrsets) > 0 {
 		// Update existing record
 		change.Deletions = []*dns.ResourceRecordSet{recList.Rrsets[0]}
+		action = providers.ActionUpdated
 	}
 	change.Additions = []*dns.ResourceRecordSet{rrset}
 
 	// Apply the change
 	changesCall := service.Changes.Create(p.ProjectID, p.ZoneName, change)
+	if err := p.Limiter.Wait(); err != nil {
+		return "", err
+	}
 	_, err = changesCall.Context(ctx).Do()
 	if err != nil {
-		return fmt.Errorf("failed to apply DNS changes: %v", err)
+		return "", wrapError("failed to apply DNS changes", err)
 	}
 
-	logrus.Infof("Record %s -> %s (%s) updated/created successfully", fqdn, rrdata, record.Type)
-	return nil
+	providers.ActionLogger(providerName, record, action, "").Infof("Record %s -> %s (%s) updated/created successfully", fqdn, rrdata, record.Type)
+	return action, nil
 }
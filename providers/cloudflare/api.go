@@ -0,0 +1,191 @@
+package cloudflare
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cfddns/providers"
+
+	"github.com/sirupsen/logrus"
+)
+
+// apiBaseURL is a variable so tests can point the provider at a stand-in.
+var apiBaseURL = "https://api.cloudflare.com/client/v4"
+
+const (
+	// maxRetries is how often a request rejected with 429 is sent again.
+	maxRetries        = 3
+	defaultRetryAfter = 5 * time.Second
+)
+
+// pageSize is the number of results requested per page of a listing.
+const pageSize = 50
+
+// response is the envelope every Cloudflare API response is wrapped in.
+type response struct {
+	Success    bool            `json:"success"`
+	Errors     []ResponseInfo  `json:"errors"`
+	Messages   []ResponseInfo  `json:"messages"`
+	Result     json.RawMessage `json:"result"`
+	ResultInfo *resultInfo     `json:"result_info"`
+}
+
+// resultInfo describes the page a listing response holds. Listings paged
+// by cursor instead of page number return the cursor of the next page.
+type resultInfo struct {
+	Page       int `json:"page"`
+	TotalPages int `json:"total_pages"`
+	Cursors    *struct {
+		After string `json:"after"`
+	} `json:"cursors"`
+}
+
+func (p *CloudflareProvider) addAuthHeaders(req *http.Request) {
+	if p.APIToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.APIToken)
+	} else if p.Email != "" && p.GlobalAPIKey != "" {
+		req.Header.Set("X-Auth-Email", p.Email)
+		req.Header.Set("X-Auth-Key", p.GlobalAPIKey)
+	}
+	req.Header.Set("Content-Type", "application/json")
+}
+
+// request calls the API endpoint at path and decodes the result of the
+// response into result, which may be nil. Calls the API reports as failed
+// return an *APIError.
+func (p *CloudflareProvider) request(method, path string, body, result interface{}) error {
+	envelope, err := p.send(method, path, body)
+	if err != nil {
+		return err
+	}
+
+	if result != nil && len(envelope.Result) > 0 {
+		if err := json.Unmarshal(envelope.Result, result); err != nil {
+			return fmt.Errorf("failed to parse response: %v", err)
+		}
+	}
+	return nil
+}
+
+// list fetches every page of the listing at path, calling add with the
+// result of each page. Pages hold pageSize results unless query sets
+// per_page.
+func (p *CloudflareProvider) list(path string, query url.Values, add func(result json.RawMessage) error) error {
+	if !query.Has("per_page") {
+		query.Set("per_page", strconv.Itoa(pageSize))
+	}
+	for page := 1; ; page++ {
+		query.Set("page", strconv.Itoa(page))
+		envelope, err := p.send("GET", path+"?"+query.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		if err := add(envelope.Result); err != nil {
+			return fmt.Errorf("failed to parse response: %v", err)
+		}
+		if envelope.ResultInfo == nil || page >= envelope.ResultInfo.TotalPages {
+			return nil
+		}
+	}
+}
+
+// send calls the API and returns the response envelope of a successful call.
+func (p *CloudflareProvider) send(method, path string, body interface{}) (*response, error) {
+	var payload io.Reader
+	if body != nil {
+		payloadBytes, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		payload = bytes.NewReader(payloadBytes)
+	}
+
+	req, err := http.NewRequest(method, apiBaseURL+path, payload)
+	if err != nil {
+		return nil, err
+	}
+	p.addAuthHeaders(req)
+
+	resp, err := p.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var envelope response
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		if resp.StatusCode >= http.StatusBadRequest {
+			return nil, newAPIError(resp.StatusCode, nil, nil)
+		}
+		return nil, fmt.Errorf("failed to parse response: %v (status code: %d)", err, resp.StatusCode)
+	}
+	if !envelope.Success || resp.StatusCode >= http.StatusBadRequest {
+		return nil, newAPIError(resp.StatusCode, envelope.Errors, envelope.Messages)
+	}
+	return &envelope, nil
+}
+
+// do sends req through the rate limiter. Requests rejected with 429 are
+// retried after the pause the API asks for, and once the Ratelimit header
+// reports the quota as used up further requests are held back until it resets.
+func (p *CloudflareProvider) do(req *http.Request) (*http.Response, error) {
+	client := &http.Client{}
+	for attempt := 0; ; attempt++ {
+		if err := p.Limiter.Wait(); err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if remaining, reset, ok := parseRateLimit(resp.Header.Get("Ratelimit")); ok && remaining == 0 {
+			p.Limiter.Backoff(reset)
+		}
+		if resp.StatusCode != http.StatusTooManyRequests || attempt == maxRetries {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		pause := providers.RetryAfter(resp, defaultRetryAfter)
+		p.Limiter.Backoff(pause)
+		logrus.WithField("provider", providerName).Warnf("Cloudflare API rate limit reached, retrying in %s", pause)
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// parseRateLimit reads the remaining requests and the time until the quota
+// resets from a Ratelimit header such as `"default";r=50;t=30`.
+func parseRateLimit(header string) (int, time.Duration, bool) {
+	remaining, reset := -1, -1
+	for _, param := range strings.Split(header, ";") {
+		key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+		if !found {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "r":
+			remaining = n
+		case "t":
+			reset = n
+		}
+	}
+	if remaining < 0 || reset < 0 {
+		return 0, 0, false
+	}
+	return remaining, time.Duration(reset) * time.Second, true
+}
@@ -0,0 +1,30 @@
+package cloudflare
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		header        string
+		wantRemaining int
+		wantReset     time.Duration
+		wantOK        bool
+	}{
+		{`"default";r=50;t=30`, 50, 30 * time.Second, true},
+		{`"default"; r=0; t=5`, 0, 5 * time.Second, true},
+		{`t=10;r=3`, 3, 10 * time.Second, true},
+		{`"default";r=50`, 0, 0, false},
+		{`"default";t=30`, 0, 0, false},
+		{`"default";r=many;t=30`, 0, 0, false},
+		{``, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		remaining, reset, ok := parseRateLimit(tt.header)
+		if remaining != tt.wantRemaining || reset != tt.wantReset || ok != tt.wantOK {
+			t.Errorf("parseRateLimit(%q) = %d, %v, %v, want %d, %v, %v", tt.header, remaining, reset, ok, tt.wantRemaining, tt.wantReset, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package cloudflare
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"cfddns/providers"
+
+	"github.com/sirupsen/logrus"
+)
+
+// recordPageSize is the number of DNS records requested per page when all
+// records of a zone are listed.
+const recordPageSize = 1000
+
+// batchRequest creates and overwrites DNS records of a zone in one request.
+// Cloudflare applies it as a whole or not at all.
+type batchRequest struct {
+	Posts []DnsRecord `json:"posts,omitempty"`
+	Puts  []DnsRecord `json:"puts,omitempty"`
+}
+
+// fetchZoneRecords lists every DNS record of the zone, keyed by name and
+// type.
+func (p *CloudflareProvider) fetchZoneRecords(zoneID string) (map[string][]DnsRecord, error) {
+	records := make(map[string][]DnsRecord)
+	query := url.Values{"per_page": {strconv.Itoa(recordPageSize)}}
+	err := p.list("/zones/"+zoneID+"/dns_records", query, func(result json.RawMessage) error {
+		var page []DnsRecord
+		if err := json.Unmarshal(result, &page); err != nil {
+			return err
+		}
+		for _, record := range page {
+			key := recordKey(record.Name, record.Type)
+			records[key] = append(records[key], record)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch DNS records: %w", err)
+	}
+	return records, nil
+}
+
+func recordKey(name, recordType string) string {
+	return normalizeName(name) + "/" + recordType
+}
+
+func (p *CloudflareProvider) BatchDNSRecords(zoneID string, batch batchRequest) error {
+	if err := p.request("POST", "/zones/"+zoneID+"/dns_records/batch", batch, nil); err != nil {
+		return fmt.Errorf("failed to update DNS records in batch: %w", err)
+	}
+	return nil
+}
+
+// CommitRecords commits records listing the DNS records of each zone once,
+// and creates and updates the records of a zone in one batch request.
+// Records of a batch the API rejects are retried one by one.
+func (p *CloudflareProvider) CommitRecords(records []providers.DNSRecord) []providers.CommitResult {
+	results := make([]providers.CommitResult, len(records))
+
+	var zoneIDs []string
+	byZone := make(map[string][]int)
+	for i, record := range records {
+		if record.Target == TargetIPList {
+			results[i].Action, results[i].Err = p.commitListItem(record)
+			continue
+		}
+		zoneID, err := p.zoneFor(record.Name)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		if _, ok := byZone[zoneID]; !ok {
+			zoneIDs = append(zoneIDs, zoneID)
+		}
+		byZone[zoneID] = append(byZone[zoneID], i)
+	}
+
+	for _, zoneID := range zoneIDs {
+		p.commitZone(zoneID, records, byZone[zoneID], results)
+	}
+	return results
+}
+
+// commitZone commits the records at indexes, which belong to the zone, and
+// stores their results.
+func (p *CloudflareProvider) commitZone(zoneID string, records []providers.DNSRecord, indexes []int, results []providers.CommitResult) {
+	existing, err := p.fetchZoneRecords(zoneID)
+	if err != nil {
+		for _, i := range indexes {
+			results[i].Err = err
+		}
+		return
+	}
+
+	changes := make(map[int]*change)
+	var writes []int
+	for _, i := range indexes {
+		record := records[i]
+		c, err := p.planChange(zoneID, record, existing[recordKey(record.Name, record.Type)])
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		if !c.write {
+			p.logChange(c)
+			results[i].Action = c.action
+			continue
+		}
+		changes[i] = c
+		writes = append(writes, i)
+	}
+
+	if len(writes) > 1 && !p.noBatch {
+		var batch batchRequest
+		for _, i := range writes {
+			if changes[i].action == providers.ActionCreated {
+				batch.Posts = append(batch.Posts, changes[i].dns)
+			} else {
+				batch.Puts = append(batch.Puts, changes[i].dns)
+			}
+		}
+		err := p.BatchDNSRecords(zoneID, batch)
+		if err == nil {
+			for _, i := range writes {
+				p.logChange(changes[i])
+				results[i].Action = changes[i].action
+			}
+			return
+		}
+		if providers.IsPermanent(err) {
+			for _, i := range writes {
+				results[i].Err = err
+			}
+			return
+		}
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusMethodNotAllowed) {
+			// The API doesn't offer batches here; stop trying
+			p.noBatch = true
+		}
+		logrus.WithField("provider", providerName).Warnf("Updating %d DNS records one by one: %v", len(writes), err)
+	}
+
+	for _, i := range writes {
+		if err := p.applyChange(zoneID, changes[i]); err != nil {
+			results[i].Err = err
+			continue
+		}
+		p.logChange(changes[i])
+		results[i].Action = changes[i].action
+	}
+}
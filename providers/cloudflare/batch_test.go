@@ -0,0 +1,140 @@
+package cloudflare
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"cfddns/providers"
+)
+
+// zoneServer stands in for the DNS records API of zone "zone", answering
+// batch requests with batchStatus.
+type zoneServer struct {
+	batchStatus int
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func (s *zoneServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	path := strings.TrimPrefix(r.URL.Path, "/client/v4")
+	switch {
+	case r.Method == "GET" && path == "/zones/zone/dns_records":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"result": []DnsRecord{
+				{ID: "home", Type: "A", Name: "home.example.com", Content: "203.0.113.1", TTL: 1},
+				{ID: "office", Type: "A", Name: "office.example.com", Content: "203.0.113.1", TTL: 1},
+			},
+			"result_info": map[string]int{"page": 1, "total_pages": 1},
+		})
+	case r.Method == "POST" && path == "/zones/zone/dns_records/batch":
+		w.WriteHeader(s.batchStatus)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": s.batchStatus == http.StatusOK,
+			"errors":  []ResponseInfo{{Code: 7000, Message: "batch failed"}},
+		})
+	case r.Method == "PUT" && strings.HasPrefix(path, "/zones/zone/dns_records/"):
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": map[string]string{}})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false})
+	}
+}
+
+func (s *zoneServer) count(request string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, r := range s.requests {
+		if r == request {
+			n++
+		}
+	}
+	return n
+}
+
+func newTestProvider(t *testing.T, server *zoneServer) *CloudflareProvider {
+	t.Helper()
+	stub := httptest.NewServer(server)
+	t.Cleanup(stub.Close)
+	baseURL := apiBaseURL
+	apiBaseURL = stub.URL + "/client/v4"
+	t.Cleanup(func() { apiBaseURL = baseURL })
+	return &CloudflareProvider{APIToken: "token", ZoneID: "zone", Limiter: providers.NewLimiter(1000, 100)}
+}
+
+func TestCommitRecordsBatchFallback(t *testing.T) {
+	records := []providers.DNSRecord{
+		{Name: "home.example.com", Type: "A", Content: "203.0.113.2", TTL: 1},
+		{Name: "office.example.com", Type: "A", Content: "203.0.113.2", TTL: 1},
+	}
+
+	tests := []struct {
+		name        string
+		batchStatus int
+		wantBatches int // batch requests over two runs
+		wantPuts    int // single updates over two runs
+		wantNoBatch bool
+	}{
+		{"batch applied", http.StatusOK, 2, 0, false},
+		{"batch unsupported", http.StatusNotFound, 1, 4, true},
+		{"batch failed", http.StatusInternalServerError, 2, 4, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &zoneServer{batchStatus: tt.batchStatus}
+			p := newTestProvider(t, server)
+
+			for run := 0; run < 2; run++ {
+				for i, result := range p.CommitRecords(records) {
+					if result.Err != nil || result.Action != providers.ActionUpdated {
+						t.Fatalf("run %d: record %d = %s, %v, want updated", run, i, result.Action, result.Err)
+					}
+				}
+			}
+
+			if got := server.count("POST /client/v4/zones/zone/dns_records/batch"); got != tt.wantBatches {
+				t.Errorf("sent %d batch requests, want %d", got, tt.wantBatches)
+			}
+			puts := server.count("PUT /client/v4/zones/zone/dns_records/home") + server.count("PUT /client/v4/zones/zone/dns_records/office")
+			if puts != tt.wantPuts {
+				t.Errorf("sent %d single updates, want %d", puts, tt.wantPuts)
+			}
+			if got := server.count("GET /client/v4/zones/zone/dns_records"); got != 2 {
+				t.Errorf("listed the zone %d times, want once per run", got)
+			}
+			if p.noBatch != tt.wantNoBatch {
+				t.Errorf("noBatch = %v, want %v", p.noBatch, tt.wantNoBatch)
+			}
+		})
+	}
+}
+
+func TestCommitRecordsPermanentBatchFailure(t *testing.T) {
+	server := &zoneServer{batchStatus: http.StatusForbidden}
+	p := newTestProvider(t, server)
+
+	results := p.CommitRecords([]providers.DNSRecord{
+		{Name: "home.example.com", Type: "A", Content: "203.0.113.2", TTL: 1},
+		{Name: "office.example.com", Type: "A", Content: "203.0.113.2", TTL: 1},
+	})
+	for i, result := range results {
+		if !providers.IsPermanent(result.Err) {
+			t.Errorf("record %d error = %v, want a permanent error", i, result.Err)
+		}
+	}
+	if server.count("PUT /client/v4/zones/zone/dns_records/home") != 0 {
+		t.Error("records were retried one by one after a permanent failure")
+	}
+}
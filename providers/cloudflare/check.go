@@ -0,0 +1,266 @@
+package cloudflare
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"cfddns/providers"
+
+	"github.com/sirupsen/logrus"
+)
+
+// dnsEditPermission is the zone permission needed to change DNS records.
+const dnsEditPermission = "#dns_records:edit"
+
+// dnsWriteGroupID and dnsWriteGroupName identify the permission group that
+// grants DNS:Edit in API token policies.
+const (
+	dnsWriteGroupID   = "4755a26eedb94da69e1066d98aa820be"
+	dnsWriteGroupName = "DNS Write"
+)
+
+const (
+	accountResource = "com.cloudflare.api.account."
+	zoneResource    = "com.cloudflare.api.account.zone."
+)
+
+type tokenStatus struct {
+	ID        string `json:"id"`
+	Status    string `json:"status"`
+	ExpiresOn string `json:"expires_on"`
+}
+
+// tokenPolicy is a policy of an API token. Resources map resource names to
+// "*", or accounts to the zones within them.
+type tokenPolicy struct {
+	Effect           string                 `json:"effect"`
+	Resources        map[string]interface{} `json:"resources"`
+	PermissionGroups []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"permission_groups"`
+}
+
+type user struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+}
+
+// Check verifies the credentials, that they can edit DNS records in the
+// zones of records, and that the IP Lists records target exist. Findings
+// are logged; the error reports how many problems were found.
+func (p *CloudflareProvider) Check(records []providers.DNSRecord) error {
+	logger := logrus.WithField("provider", providerName)
+
+	tokenPath, err := p.verifyCredentials(logger)
+	if err != nil {
+		return err
+	}
+
+	var policies []tokenPolicy
+	var policiesErr error
+	fetchedPolicies := false
+	tokenPolicies := func() ([]tokenPolicy, error) {
+		if !fetchedPolicies {
+			fetchedPolicies = true
+			policies, policiesErr = p.fetchTokenPolicies(tokenPath)
+		}
+		return policies, policiesErr
+	}
+
+	problems := 0
+	if err := p.listZones(); err != nil {
+		logger.WithError(err).Errorf("Cannot list zones: %v", err)
+		problems++
+	} else {
+		var names []string
+		for _, zone := range p.zones {
+			names = append(names, zone.Name)
+		}
+		logger.Infof("Credentials can access %d zones: %s", len(names), strings.Join(names, ", "))
+	}
+
+	checkedZones := make(map[string]bool)
+	checkedLists := make(map[string]bool)
+	for _, record := range records {
+		if record.Target == TargetIPList {
+			if checkedLists[record.Name] {
+				continue
+			}
+			checkedLists[record.Name] = true
+			if p.AccountID == "" {
+				logger.Errorf("IP list %s requires accountId", record.Name)
+				problems++
+			} else if _, err := p.listID(record.Name); err != nil {
+				logger.WithError(err).Errorf("Cannot use IP list %s: %v", record.Name, err)
+				problems++
+			} else {
+				logger.Infof("IP list %s found in account %s", record.Name, p.AccountID)
+			}
+			continue
+		}
+
+		zoneID, err := p.zoneFor(record.Name)
+		if err != nil {
+			logger.WithField("record", record.Name).WithError(err).Errorf("Cannot find the zone of %s: %v", record.Name, err)
+			problems++
+			continue
+		}
+		if checkedZones[zoneID] {
+			continue
+		}
+		checkedZones[zoneID] = true
+		if !p.checkZone(logger, zoneID, tokenPolicies) {
+			problems++
+		}
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("found %d problem(s)", problems)
+	}
+	return nil
+}
+
+// verifyCredentials verifies an API token, which may belong to the user or
+// to the account, or else the global API key. It returns the path the
+// token's details are read from, which is empty for the global API key.
+func (p *CloudflareProvider) verifyCredentials(logger *logrus.Entry) (string, error) {
+	if p.APIToken == "" {
+		var account user
+		if err := p.request("GET", "/user", nil, &account); err != nil {
+			return "", fmt.Errorf("failed to verify global API key: %w", err)
+		}
+		logger.Infof("Global API key is valid for %s", account.Email)
+		return "", nil
+	}
+
+	var token tokenStatus
+	tokensPath := "/user/tokens/"
+	err := p.request("GET", tokensPath+"verify", nil, &token)
+	if err != nil && p.AccountID != "" {
+		tokensPath = "/accounts/" + p.AccountID + "/tokens/"
+		err = p.request("GET", tokensPath+"verify", nil, &token)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to verify API token: %w", err)
+	}
+	if token.Status != "active" {
+		return "", fmt.Errorf("API token %s is %s", token.ID, token.Status)
+	}
+	if token.ExpiresOn != "" {
+		logger.Infof("API token %s is active until %s", token.ID, token.ExpiresOn)
+	} else {
+		logger.Infof("API token %s is active", token.ID)
+	}
+	return tokensPath + token.ID, nil
+}
+
+// errNoPolicies is returned for the global API key, which has no policies
+// to read.
+var errNoPolicies = errors.New("the global API key has no policies")
+
+// fetchTokenPolicies reads the policies of the API token at tokenPath. This
+// needs the token to have API Tokens:Read.
+func (p *CloudflareProvider) fetchTokenPolicies(tokenPath string) ([]tokenPolicy, error) {
+	if tokenPath == "" {
+		return nil, errNoPolicies
+	}
+	var token struct {
+		Policies []tokenPolicy `json:"policies"`
+	}
+	if err := p.request("GET", tokenPath, nil, &token); err != nil {
+		return nil, fmt.Errorf("failed to read API token policies: %w", err)
+	}
+	return token.Policies, nil
+}
+
+// checkZone reports whether the credentials can edit DNS records in the
+// zone, judging by the permissions listed with it, or else by the policies
+// of the API token.
+func (p *CloudflareProvider) checkZone(logger *logrus.Entry, zoneID string, tokenPolicies func() ([]tokenPolicy, error)) bool {
+	var zone *Zone
+	for i := range p.zones {
+		if p.zones[i].ID == zoneID {
+			zone = &p.zones[i]
+		}
+	}
+	if zone == nil {
+		logger.Errorf("Zone %s is not among the zones the credentials can access; the API token needs Zone:Read for it", zoneID)
+		return false
+	}
+
+	logger = logger.WithField("zone", zone.Name)
+	if len(zone.Permissions) == 0 {
+		policies, err := tokenPolicies()
+		if errors.Is(err, errNoPolicies) || IsErrorKind(err, ErrorPermission) || IsErrorKind(err, ErrorAuth) {
+			// Reading a token's policies needs API Tokens:Read, which a token
+			// scoped to DNS rightly lacks
+			logger.WithError(err).Warnf("Could not confirm DNS:Edit on zone %s: the zone lists no permissions and %v", zone.Name, err)
+			return true
+		}
+		if err != nil {
+			logger.WithError(err).Errorf("Cannot tell whether the credentials have DNS:Edit on zone %s: %v", zone.Name, err)
+			return false
+		}
+		if !canEditDNS(policies, zone.ID, zone.Account.ID) {
+			logger.Errorf("API token policies do not grant DNS:Edit on zone %s", zone.Name)
+			return false
+		}
+		logger.Infof("API token policies grant DNS:Edit on zone %s", zone.Name)
+		return true
+	}
+	for _, permission := range zone.Permissions {
+		if permission == dnsEditPermission {
+			logger.Infof("Credentials have DNS:Edit on zone %s", zone.Name)
+			return true
+		}
+	}
+	logger.Errorf("Credentials lack DNS:Edit on zone %s", zone.Name)
+	return false
+}
+
+// canEditDNS reports whether policies allow, and do not deny, DNS Write on
+// the zone.
+func canEditDNS(policies []tokenPolicy, zoneID, accountID string) bool {
+	allowed := false
+	for _, policy := range policies {
+		if !grantsDNSWrite(policy) || !coversZone(policy.Resources, zoneID, accountID) {
+			continue
+		}
+		if policy.Effect == "deny" {
+			return false
+		}
+		allowed = true
+	}
+	return allowed
+}
+
+func grantsDNSWrite(policy tokenPolicy) bool {
+	for _, group := range policy.PermissionGroups {
+		if group.ID == dnsWriteGroupID || group.Name == dnsWriteGroupName {
+			return true
+		}
+	}
+	return false
+}
+
+// coversZone reports whether resources include the zone, directly, through
+// a wildcard, or through the account that owns it.
+func coversZone(resources map[string]interface{}, zoneID, accountID string) bool {
+	for resource, scope := range resources {
+		switch resource {
+		case zoneResource + zoneID, zoneResource + "*":
+			return true
+		case accountResource + accountID, accountResource + "*":
+			if nested, ok := scope.(map[string]interface{}); ok {
+				if coversZone(nested, zoneID, accountID) {
+					return true
+				}
+			} else if scope == "*" {
+				return true
+			}
+		}
+	}
+	return false
+}
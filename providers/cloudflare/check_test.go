@@ -0,0 +1,99 @@
+package cloudflare
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestCanEditDNS(t *testing.T) {
+	const (
+		zoneID    = "023e105f4ecef8ad9ca31a8372d0c353"
+		accountID = "01a7362d577a6c3019a474fd6f485823"
+	)
+	dnsWrite := `"permission_groups": [{"id": "4755a26eedb94da69e1066d98aa820be", "name": "DNS Write"}]`
+	dnsRead := `"permission_groups": [{"id": "82e64a83756745bbbb1c9c2701bf816b", "name": "DNS Read"}]`
+
+	tests := []struct {
+		name     string
+		policies string
+		want     bool
+	}{
+		{"zone", `[{"effect": "allow", "resources": {"com.cloudflare.api.account.zone.` + zoneID + `": "*"}, ` + dnsWrite + `}]`, true},
+		{"other zone", `[{"effect": "allow", "resources": {"com.cloudflare.api.account.zone.aaaa": "*"}, ` + dnsWrite + `}]`, false},
+		{"all zones", `[{"effect": "allow", "resources": {"com.cloudflare.api.account.zone.*": "*"}, ` + dnsWrite + `}]`, true},
+		{"zones of account", `[{"effect": "allow", "resources": {"com.cloudflare.api.account.` + accountID + `": {"com.cloudflare.api.account.zone.*": "*"}}, ` + dnsWrite + `}]`, true},
+		{"zones of other account", `[{"effect": "allow", "resources": {"com.cloudflare.api.account.bbbb": {"com.cloudflare.api.account.zone.*": "*"}}, ` + dnsWrite + `}]`, false},
+		{"read only", `[{"effect": "allow", "resources": {"com.cloudflare.api.account.zone.` + zoneID + `": "*"}, ` + dnsRead + `}]`, false},
+		{"denied", `[
+			{"effect": "allow", "resources": {"com.cloudflare.api.account.zone.*": "*"}, ` + dnsWrite + `},
+			{"effect": "deny", "resources": {"com.cloudflare.api.account.zone.` + zoneID + `": "*"}, ` + dnsWrite + `}
+		]`, false},
+		{"no policies", `[]`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var policies []tokenPolicy
+			if err := json.Unmarshal([]byte(tt.policies), &policies); err != nil {
+				t.Fatal(err)
+			}
+			if got := canEditDNS(policies, zoneID, accountID); got != tt.want {
+				t.Errorf("canEditDNS() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckZoneWithoutPermissions(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	zone := Zone{ID: "zone", Name: "example.com"}
+	zone.Account.ID = "account"
+	p := &CloudflareProvider{zones: []Zone{zone}}
+
+	granted := func() ([]tokenPolicy, error) {
+		return []tokenPolicy{{
+			Effect:    "allow",
+			Resources: map[string]interface{}{zoneResource + "zone": "*"},
+			PermissionGroups: []struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			}{{ID: dnsWriteGroupID, Name: dnsWriteGroupName}},
+		}}, nil
+	}
+	notGranted := func() ([]tokenPolicy, error) {
+		return []tokenPolicy{}, nil
+	}
+	forbidden := func() ([]tokenPolicy, error) {
+		return nil, fmt.Errorf("failed to read API token policies: %w", newAPIError(http.StatusForbidden, []ResponseInfo{{Code: 9109, Message: "Unauthorized to access requested resource"}}, nil))
+	}
+	globalKey := func() ([]tokenPolicy, error) {
+		return nil, errNoPolicies
+	}
+	unreachable := func() ([]tokenPolicy, error) {
+		return nil, errors.New("connection reset")
+	}
+
+	tests := []struct {
+		name     string
+		policies func() ([]tokenPolicy, error)
+		want     bool
+	}{
+		{"policies grant DNS Write", granted, true},
+		{"policies lack DNS Write", notGranted, false},
+		{"policies not readable by the token", forbidden, true},
+		{"global API key", globalKey, true},
+		{"policies not fetched", unreachable, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.checkZone(logger, "zone", tt.policies); got != tt.want {
+				t.Errorf("checkZone() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,240 +1,312 @@
 package cloudflare
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"net/http"
+	"net/url"
+	"strings"
 
 	"cfddns/providers"
 
 	"github.com/sirupsen/logrus"
 )
 
+const providerName = "cloudflare"
+
+// Policies for several existing records with the same name and type.
+const (
+	// DuplicatesSingle deletes the extra records so exactly one remains.
+	DuplicatesSingle = "single"
+	// DuplicatesFirst updates the first record and leaves the others alone.
+	DuplicatesFirst = "first"
+	// DuplicatesError refuses to update any of them.
+	DuplicatesError = "error"
+)
+
+// DefaultOwnershipMarker is stamped on the records cfddns creates unless
+// ownershipMarker is set.
+const DefaultOwnershipMarker = "managed-by:cfddns"
+
+// Where the ownership marker is stored on a record.
+const (
+	MarkComment = "comment"
+	MarkTags    = "tags"
+)
+
 type CloudflareProvider struct {
 	Email        string
 	GlobalAPIKey string
 	APIToken     string
 	ZoneName     string
 	ZoneID       string
-}
-
-type Zone struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	// AccountID is the account holding the IP Lists records can target
+	AccountID string
+	Limiter   *providers.Limiter
+
+	// OwnershipMarker is stamped on created and adopted records, in their
+	// comment or, with MarkWith set to tags, as a tag. Empty disables it.
+	OwnershipMarker string
+	MarkWith        string
+	// EnforceOwnership refuses to change existing records that lack the
+	// marker, unless the record is set to adopt them.
+	EnforceOwnership bool
+
+	// zones caches the zones the credentials can list, for records whose
+	// zone is detected from their name
+	zones []Zone
+	// lists caches the IDs of the account's IP Lists by name
+	lists map[string]string
+	// noBatch is set once the API turned down a batch request as unsupported
+	noBatch bool
 }
 
 type DnsRecord struct {
-	ID      string `json:"id"`
+	ID      string `json:"id,omitempty"`
 	Type    string `json:"type"`
 	Name    string `json:"name"`
 	Content string `json:"content"`
 	Proxied bool   `json:"proxied"`
 	TTL     int    `json:"ttl"`
+	// Updates replace the whole record, so comment and tags are carried over
+	Comment string   `json:"comment,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
 }
 
-func (p *CloudflareProvider) addAuthHeaders(req *http.Request) {
-	if p.APIToken != "" {
-		req.Header.Set("Authorization", "Bearer "+p.APIToken)
-	} else if p.Email != "" && p.GlobalAPIKey != "" {
-		req.Header.Set("X-Auth-Email", p.Email)
-		req.Header.Set("X-Auth-Key", p.GlobalAPIKey)
+// owns reports whether record carries the ownership marker, as a word of its
+// comment or as one of its tags.
+func (p *CloudflareProvider) owns(record DnsRecord) bool {
+	if p.OwnershipMarker == "" {
+		return false
 	}
-	req.Header.Set("Content-Type", "application/json")
+	for _, word := range strings.Fields(record.Comment) {
+		if word == p.OwnershipMarker {
+			return true
+		}
+	}
+	for _, tag := range record.Tags {
+		if tag == p.OwnershipMarker {
+			return true
+		}
+	}
+	return false
 }
 
-func (p *CloudflareProvider) fetchZoneID() (string, error) {
-	if p.ZoneID != "" {
-		return p.ZoneID, nil
+// mark stamps the ownership marker on record, keeping its existing comment
+// or tags.
+func (p *CloudflareProvider) mark(record *DnsRecord) {
+	if p.OwnershipMarker == "" || p.owns(*record) {
+		return
 	}
-	apiUrl := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones?name=%s", p.ZoneName)
-
-	req, err := http.NewRequest("GET", apiUrl, nil)
-	if err != nil {
-		return "", err
+	if p.MarkWith == MarkTags {
+		record.Tags = append(record.Tags, p.OwnershipMarker)
+		return
 	}
-	p.addAuthHeaders(req)
+	record.Comment = strings.TrimSpace(record.Comment + " " + p.OwnershipMarker)
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
+// checkOwnership refuses changes to an existing record that lacks the
+// ownership marker when ownership is enforced and the record isn't adopted.
+func (p *CloudflareProvider) checkOwnership(record providers.DNSRecord, existing DnsRecord) error {
+	if !p.EnforceOwnership || record.Adopt || p.owns(existing) {
+		return nil
 	}
-	defer resp.Body.Close()
+	return fmt.Errorf("refusing to change %s record %s (id: %s): it lacks the ownership marker %q; set adopt: true on the record to take it over", existing.Type, existing.Name, existing.ID, p.OwnershipMarker)
+}
 
-	var result struct {
-		Success bool       `json:"success"`
-		Errors  []struct{} `json:"errors"`
-		Result  []Zone     `json:"result"`
+func (p *CloudflareProvider) fetchDNSRecords(zoneID, recordName, recordType string) ([]DnsRecord, error) {
+	var records []DnsRecord
+	query := url.Values{"type": {recordType}, "name": {recordName}}
+	if err := p.request("GET", "/zones/"+zoneID+"/dns_records?"+query.Encode(), nil, &records); err != nil {
+		return nil, fmt.Errorf("failed to fetch DNS records: %w", err)
 	}
+	return records, nil
+}
 
-	err = json.NewDecoder(resp.Body).Decode(&result)
-	if err != nil {
-		return "", err
+func (p *CloudflareProvider) UpdateDNSRecord(zoneID string, record DnsRecord) error {
+	if err := p.request("PUT", "/zones/"+zoneID+"/dns_records/"+record.ID, record, nil); err != nil {
+		return fmt.Errorf("failed to update DNS record: %w", err)
 	}
+	return nil
+}
 
-	if !result.Success || len(result.Result) == 0 {
-		return "", fmt.Errorf("failed to fetch zone ID for domain: %s", p.ZoneName)
+func (p *CloudflareProvider) CreateDNSRecord(zoneID string, record DnsRecord) error {
+	if err := p.request("POST", "/zones/"+zoneID+"/dns_records", record, nil); err != nil {
+		return fmt.Errorf("failed to create DNS record: %w", err)
 	}
-
-	p.ZoneID = result.Result[0].ID
-	return p.ZoneID, nil
+	return nil
 }
 
-func (p *CloudflareProvider) fetchDNSRecord(recordName, recordType string) (*DnsRecord, error) {
-	zoneID, err := p.fetchZoneID()
-	if err != nil {
-		return nil, err
+func (p *CloudflareProvider) DeleteDNSRecord(zoneID, recordID string) error {
+	if err := p.request("DELETE", "/zones/"+zoneID+"/dns_records/"+recordID, nil, nil); err != nil {
+		return fmt.Errorf("failed to delete DNS record: %w", err)
 	}
+	return nil
+}
 
-	apiUrl := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records?type=%s&name=%s", zoneID, recordType, recordName)
-
-	req, err := http.NewRequest("GET", apiUrl, nil)
-	if err != nil {
-		return nil, err
+// resolveDuplicates picks the record to update among the existing records
+// with the name and type of record, and applies the record's duplicates
+// policy to the others. It reports whether extra records were deleted.
+func (p *CloudflareProvider) resolveDuplicates(zoneID string, record providers.DNSRecord, existing []DnsRecord) (*DnsRecord, bool, error) {
+	if len(existing) == 0 {
+		return nil, false, nil
 	}
-	p.addAuthHeaders(req)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	if len(existing) == 1 {
+		return &existing[0], false, nil
 	}
-	defer resp.Body.Close()
 
-	var result struct {
-		Success bool        `json:"success"`
-		Errors  []struct{}  `json:"errors"`
-		Result  []DnsRecord `json:"result"`
+	policy := record.Duplicates
+	if policy == "" {
+		policy = DuplicatesFirst
 	}
 
-	err = json.NewDecoder(resp.Body).Decode(&result)
-	if err != nil {
-		return nil, err
+	keep := 0
+	if policy == DuplicatesSingle {
+		// Keep a record that already has the address, so it needn't change
+		for i, candidate := range existing {
+			if candidate.Content == record.Content {
+				keep = i
+				break
+			}
+		}
 	}
-
-	if !result.Success {
-		return nil, fmt.Errorf("failed to fetch DNS records")
+	for i, extra := range existing {
+		if i != keep {
+			providers.Logger(providerName, record).WithField("id", extra.ID).Warnf("Found extra DNS record: %s -> %s (id: %s, duplicates: %s)", extra.Name, extra.Content, extra.ID, policy)
+		}
 	}
 
-	if len(result.Result) > 0 {
-		return &result.Result[0], nil
+	switch policy {
+	case DuplicatesError:
+		return nil, false, fmt.Errorf("found %d %s records named %s, refusing to update them (duplicates: error)", len(existing), record.Type, record.Name)
+	case DuplicatesSingle:
+		for i, extra := range existing {
+			if i == keep {
+				continue
+			}
+			if err := p.checkOwnership(record, extra); err != nil {
+				return nil, false, err
+			}
+			if err := p.DeleteDNSRecord(zoneID, extra.ID); err != nil {
+				return nil, false, err
+			}
+			providers.Logger(providerName, record).WithFields(logrus.Fields{
+				"action": "deleted",
+				"old":    extra.Content,
+			}).Infof("Deleted extra DNS record: %s -> %s (id: %s)", extra.Name, extra.Content, extra.ID)
+		}
+		return &existing[keep], true, nil
 	}
+	return &existing[keep], false, nil
+}
 
-	return nil, nil
+// change is what committing a record takes: the DNS record as it should
+// be, the action that gets it there and the content it replaces. write is
+// set when the record has to be created or updated.
+type change struct {
+	record   providers.DNSRecord
+	dns      DnsRecord
+	action   providers.Action
+	old      string
+	write    bool
+	adopting bool
 }
 
-func (p *CloudflareProvider) UpdateDNSRecord(record DnsRecord) error {
-	zoneID, err := p.fetchZoneID()
+// planChange compares record with the existing records of its name and
+// type, resolving duplicates on the way, and returns the change it takes.
+func (p *CloudflareProvider) planChange(zoneID string, record providers.DNSRecord, existing []DnsRecord) (*change, error) {
+	existingRecord, deleted, err := p.resolveDuplicates(zoneID, record, existing)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	apiUrl := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records/%s", zoneID, record.ID)
-
-	payloadBytes, err := json.Marshal(record)
-	if err != nil {
-		return err
+	c := &change{
+		record: record,
+		dns: DnsRecord{
+			Type:    record.Type,
+			Name:    record.Name,
+			Content: record.Content,
+			TTL:     record.TTL,
+			Proxied: record.Proxied,
+		},
 	}
 
-	req, err := http.NewRequest("PUT", apiUrl, bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		return err
+	if existingRecord == nil {
+		p.mark(&c.dns)
+		c.action = providers.ActionCreated
+		c.write = true
+		return c, nil
 	}
-	p.addAuthHeaders(req)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+	c.dns.ID = existingRecord.ID
+	c.dns.Comment = existingRecord.Comment
+	c.dns.Tags = existingRecord.Tags
+	c.old = existingRecord.Content
+	c.adopting = record.Adopt && p.OwnershipMarker != "" && !p.owns(*existingRecord)
+	if c.adopting {
+		p.mark(&c.dns)
 	}
-	defer resp.Body.Close()
-
-	var responseBody map[string]interface{}
-	err = json.NewDecoder(resp.Body).Decode(&responseBody)
-	if err != nil {
-		return fmt.Errorf("failed to parse response: %v", err)
+	switch {
+	case existingRecord.Content != c.dns.Content || existingRecord.TTL != c.dns.TTL || existingRecord.Proxied != c.dns.Proxied || c.adopting:
+		if err := p.checkOwnership(record, *existingRecord); err != nil {
+			return nil, err
+		}
+		c.action = providers.ActionUpdated
+		c.write = true
+	case deleted:
+		// Removing the extra records changed what the name resolves to
+		c.action = providers.ActionUpdated
+	default:
+		c.action = providers.ActionUnchanged
 	}
+	return c, nil
+}
 
-	success, ok := responseBody["success"].(bool)
-	if !ok || !success {
-		return fmt.Errorf("failed to update DNS record: %v (status code: %d)", responseBody, resp.StatusCode)
+// applyChange creates or updates the record of c on its own.
+func (p *CloudflareProvider) applyChange(zoneID string, c *change) error {
+	if c.action == providers.ActionCreated {
+		return p.CreateDNSRecord(zoneID, c.dns)
 	}
-
-	return nil
+	return p.UpdateDNSRecord(zoneID, c.dns)
 }
 
-func (p *CloudflareProvider) CreateDNSRecord(record DnsRecord) error {
-	zoneID, err := p.fetchZoneID()
-	if err != nil {
-		return err
+// logChange logs the outcome of a change once it has been applied.
+func (p *CloudflareProvider) logChange(c *change) {
+	if c.adopting {
+		providers.Logger(providerName, c.record).WithField("id", c.dns.ID).Infof("Adopted DNS record: %s (marked %s)", c.dns.Name, p.OwnershipMarker)
 	}
-
-	apiUrl := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records", zoneID)
-
-	payloadBytes, err := json.Marshal(record)
-	if err != nil {
-		return err
+	logger := providers.ActionLogger(providerName, c.record, c.action, c.old)
+	switch {
+	case c.action == providers.ActionCreated:
+		logger.Infof("Created new DNS record: %s -> %s (TTL: %d, Proxied: %v)", c.dns.Name, c.dns.Content, c.dns.TTL, c.dns.Proxied)
+	case c.action == providers.ActionUpdated && c.write:
+		logger.Infof("Updated DNS record: %s -> %s (TTL: %d, Proxied: %v)", c.dns.Name, c.dns.Content, c.dns.TTL, c.dns.Proxied)
+	case c.action == providers.ActionUpdated:
+		logger.Infof("Removed duplicates of DNS record: %s -> %s (TTL: %d, Proxied: %v)", c.dns.Name, c.dns.Content, c.dns.TTL, c.dns.Proxied)
+	default:
+		logger.Infof("Already up-to-date: %s -> %s (TTL: %d, Proxied: %v)", c.dns.Name, c.dns.Content, c.dns.TTL, c.dns.Proxied)
 	}
+}
 
-	req, err := http.NewRequest("POST", apiUrl, bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		return err
+func (p *CloudflareProvider) CommitRecord(record providers.DNSRecord) (providers.Action, error) {
+	if record.Target == TargetIPList {
+		return p.commitListItem(record)
 	}
-	p.addAuthHeaders(req)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	zoneID, err := p.zoneFor(record.Name)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer resp.Body.Close()
-
-	var responseBody map[string]interface{}
-	err = json.NewDecoder(resp.Body).Decode(&responseBody)
+	existingRecords, err := p.fetchDNSRecords(zoneID, record.Name, record.Type)
 	if err != nil {
-		return fmt.Errorf("failed to parse response: %v", err)
-	}
-
-	success, ok := responseBody["success"].(bool)
-	if !ok || !success {
-		return fmt.Errorf("failed to create DNS record: %v (status code: %d)", responseBody, resp.StatusCode)
+		return "", err
 	}
-
-	return nil
-}
-
-func (p *CloudflareProvider) CommitRecord(record providers.DNSRecord) error {
-	existingRecord, err := p.fetchDNSRecord(record.Name, record.Type)
+	c, err := p.planChange(zoneID, record, existingRecords)
 	if err != nil {
-		return err
-	}
-
-	dnsRecord := DnsRecord{
-		Type:    record.Type,
-		Name:    record.Name,
-		Content: record.Content,
-		TTL:     record.TTL,
-		Proxied: record.Proxied,
+		return "", err
 	}
-
-	if existingRecord != nil {
-		dnsRecord.ID = existingRecord.ID
-		if existingRecord.Content != dnsRecord.Content || existingRecord.TTL != dnsRecord.TTL || existingRecord.Proxied != dnsRecord.Proxied {
-			err := p.UpdateDNSRecord(dnsRecord)
-			if err != nil {
-				return err
-			}
-			logrus.Infof("Updated DNS record: %s -> %s (TTL: %d, Proxied: %v)", dnsRecord.Name, dnsRecord.Content, dnsRecord.TTL, dnsRecord.Proxied)
-		} else {
-			logrus.Infof("Already up-to-date: %s -> %s (TTL: %d, Proxied: %v)", dnsRecord.Name, dnsRecord.Content, dnsRecord.TTL, dnsRecord.Proxied)
+	if c.write {
+		if err := p.applyChange(zoneID, c); err != nil {
+			return "", err
 		}
-	} else {
-		err := p.CreateDNSRecord(dnsRecord)
-		if err != nil {
-			return err
-		}
-		logrus.Infof("Created new DNS record: %s -> %s (TTL: %d, Proxied: %v)", dnsRecord.Name, dnsRecord.Content, dnsRecord.TTL, dnsRecord.Proxied)
 	}
-	return nil
+	p.logChange(c)
+	return c.action, nil
 }
@@ -0,0 +1,44 @@
+package cloudflare
+
+import "testing"
+
+func TestOwns(t *testing.T) {
+	tests := []struct {
+		name    string
+		comment string
+		tags    []string
+		want    bool
+	}{
+		{"marker as comment", "managed-by:cfddns", nil, true},
+		{"marker after comment", "home router managed-by:cfddns", nil, true},
+		{"marker before comment", "managed-by:cfddns\thome router", nil, true},
+		{"marker with suffix", "managed-by:cfddns-staging", nil, false},
+		{"marker with prefix", "not-managed-by:cfddns", nil, false},
+		{"marker in word", "xmanaged-by:cfddnsx", nil, false},
+		{"marker as tag", "", []string{"env:home", "managed-by:cfddns"}, true},
+		{"tag with suffix", "", []string{"managed-by:cfddns-staging"}, false},
+		{"no marker", "home router", nil, false},
+	}
+
+	p := &CloudflareProvider{OwnershipMarker: DefaultOwnershipMarker}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.owns(DnsRecord{Comment: tt.comment, Tags: tt.tags}); got != tt.want {
+				t.Errorf("owns(comment %q, tags %v) = %v, want %v", tt.comment, tt.tags, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarkStampsOnce(t *testing.T) {
+	p := &CloudflareProvider{OwnershipMarker: DefaultOwnershipMarker}
+	record := DnsRecord{Comment: "home router managed-by:cfddns-staging"}
+	p.mark(&record)
+	if want := "home router managed-by:cfddns-staging managed-by:cfddns"; record.Comment != want {
+		t.Fatalf("mark() comment = %q, want %q", record.Comment, want)
+	}
+	p.mark(&record)
+	if want := "home router managed-by:cfddns-staging managed-by:cfddns"; record.Comment != want {
+		t.Errorf("second mark() comment = %q, want %q", record.Comment, want)
+	}
+}
@@ -0,0 +1,139 @@
+package cloudflare
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cfddns/providers"
+)
+
+// ErrorKind groups Cloudflare API errors by what it takes to fix them.
+type ErrorKind string
+
+const (
+	// ErrorAuth means the credentials are invalid, expired or malformed.
+	ErrorAuth ErrorKind = "auth"
+	// ErrorPermission means the credentials are valid but lack a permission.
+	ErrorPermission ErrorKind = "permission"
+	// ErrorValidation means the API rejected the request itself.
+	ErrorValidation ErrorKind = "validation"
+	// ErrorRateLimit means too many requests were sent.
+	ErrorRateLimit ErrorKind = "ratelimit"
+	ErrorOther     ErrorKind = "other"
+)
+
+// Error codes Cloudflare uses for missing, malformed or invalid credentials.
+var authErrorCodes = []int{
+	1000,  // Invalid API token
+	6003,  // Invalid request headers
+	6100,  // Invalid format for X-Auth-Email header
+	6101,  // Invalid format for X-Auth-Key header
+	6102,  // Invalid format for X-Auth-Email header
+	6103,  // Invalid format for X-Auth-Key header
+	6111,  // Invalid format for Authorization header
+	9103,  // Unknown X-Auth-Key or X-Auth-Email
+	9106,  // Missing X-Auth-Key, X-Auth-Email or Authorization headers
+	9107,  // Missing X-Auth-Key or X-Auth-Email headers
+	10000, // Authentication error
+}
+
+const rateLimitErrorCode = 971
+
+// ResponseInfo is an entry of the errors or messages of an API response.
+type ResponseInfo struct {
+	Code       int            `json:"code"`
+	Message    string         `json:"message"`
+	ErrorChain []ResponseInfo `json:"error_chain,omitempty"`
+}
+
+// APIError is a request the Cloudflare API reported as failed.
+type APIError struct {
+	StatusCode int
+	Kind       ErrorKind
+	Errors     []ResponseInfo
+	Messages   []ResponseInfo
+}
+
+// newAPIError classifies a failed response. Authentication and permission
+// errors are wrapped as permanent so the circuit breaker can act on them.
+func newAPIError(statusCode int, errs, messages []ResponseInfo) error {
+	apiErr := &APIError{
+		StatusCode: statusCode,
+		Kind:       classify(statusCode, errs),
+		Errors:     errs,
+		Messages:   messages,
+	}
+	if apiErr.Kind == ErrorAuth || apiErr.Kind == ErrorPermission {
+		return providers.Permanent(apiErr)
+	}
+	return apiErr
+}
+
+func classify(statusCode int, errs []ResponseInfo) ErrorKind {
+	switch {
+	case statusCode == http.StatusTooManyRequests || hasCode(errs, rateLimitErrorCode):
+		return ErrorRateLimit
+	case statusCode == http.StatusUnauthorized || hasCode(errs, authErrorCodes...):
+		// Cloudflare answers 403 with code 10000 both for bad credentials and
+		// for tokens missing a permission; the status tells them apart.
+		if statusCode == http.StatusForbidden && !hasCode(errs, 1000, 9103, 9106, 9107) {
+			return ErrorPermission
+		}
+		return ErrorAuth
+	case statusCode == http.StatusForbidden:
+		return ErrorPermission
+	case statusCode == http.StatusBadRequest || statusCode == http.StatusConflict || statusCode == http.StatusUnprocessableEntity:
+		return ErrorValidation
+	default:
+		return ErrorOther
+	}
+}
+
+func hasCode(infos []ResponseInfo, codes ...int) bool {
+	for _, info := range infos {
+		for _, code := range codes {
+			if info.Code == code {
+				return true
+			}
+		}
+		if hasCode(info.ErrorChain, codes...) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *APIError) Error() string {
+	var details []string
+	for _, info := range e.Errors {
+		details = append(details, describe(info))
+	}
+	if len(details) == 0 {
+		details = append(details, http.StatusText(e.StatusCode))
+	}
+
+	message := fmt.Sprintf("Cloudflare API %s error (status code: %d): %s", e.Kind, e.StatusCode, strings.Join(details, "; "))
+	switch e.Kind {
+	case ErrorAuth:
+		message += "; check apiToken, or email and globalApiKey"
+	case ErrorPermission:
+		message += "; the API token needs Zone:Read and DNS:Edit permissions for the zone, and Account Filter Lists:Edit for IP lists"
+	}
+	return message
+}
+
+func describe(info ResponseInfo) string {
+	description := fmt.Sprintf("%s (code %d)", info.Message, info.Code)
+	for _, cause := range info.ErrorChain {
+		description += ": " + describe(cause)
+	}
+	return description
+}
+
+// IsErrorKind reports whether err is an APIError of kind.
+func IsErrorKind(err error, kind ErrorKind) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Kind == kind
+}
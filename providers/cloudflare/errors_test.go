@@ -0,0 +1,69 @@
+package cloudflare
+
+import (
+	"net/http"
+	"testing"
+
+	"cfddns/providers"
+)
+
+func TestClassify(t *testing.T) {
+	codes := func(codes ...int) []ResponseInfo {
+		var infos []ResponseInfo
+		for _, code := range codes {
+			infos = append(infos, ResponseInfo{Code: code})
+		}
+		return infos
+	}
+
+	tests := []struct {
+		name       string
+		statusCode int
+		errs       []ResponseInfo
+		want       ErrorKind
+	}{
+		{"401 without codes", http.StatusUnauthorized, nil, ErrorAuth},
+		{"401 invalid token", http.StatusUnauthorized, codes(1000), ErrorAuth},
+		{"401 authentication error", http.StatusUnauthorized, codes(10000), ErrorAuth},
+		{"403 without codes", http.StatusForbidden, nil, ErrorPermission},
+		{"403 invalid token", http.StatusForbidden, codes(1000), ErrorAuth},
+		{"403 unknown key or email", http.StatusForbidden, codes(9103), ErrorAuth},
+		{"403 authentication error", http.StatusForbidden, codes(10000), ErrorPermission},
+		{"403 authentication error with unknown key", http.StatusForbidden, codes(10000, 9103), ErrorAuth},
+		{"403 code in error chain", http.StatusForbidden, []ResponseInfo{{Code: 10000, ErrorChain: codes(1000)}}, ErrorAuth},
+		{"400 invalid token", http.StatusBadRequest, codes(1000), ErrorAuth},
+		{"400 validation", http.StatusBadRequest, codes(9005), ErrorValidation},
+		{"409 conflict", http.StatusConflict, codes(81057), ErrorValidation},
+		{"429", http.StatusTooManyRequests, nil, ErrorRateLimit},
+		{"rate limit code", http.StatusBadRequest, codes(971), ErrorRateLimit},
+		{"500", http.StatusInternalServerError, nil, ErrorOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classify(tt.statusCode, tt.errs); got != tt.want {
+				t.Errorf("classify(%d, %v) = %s, want %s", tt.statusCode, tt.errs, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewAPIErrorPermanent(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		want       bool
+	}{
+		{http.StatusUnauthorized, true},
+		{http.StatusForbidden, true},
+		{http.StatusBadRequest, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+	}
+
+	for _, tt := range tests {
+		err := newAPIError(tt.statusCode, nil, nil)
+		if got := providers.IsPermanent(err); got != tt.want {
+			t.Errorf("IsPermanent(newAPIError(%d)) = %v, want %v", tt.statusCode, got, tt.want)
+		}
+	}
+}
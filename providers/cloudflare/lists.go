@@ -0,0 +1,227 @@
+package cloudflare
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"cfddns/providers"
+)
+
+// TargetIPList makes a record keep an entry of an account IP List in sync
+// instead of a DNS record. The record's name is the name of the list.
+const TargetIPList = "ipList"
+
+const (
+	// operationPollInterval and operationPollAttempts bound how long to wait
+	// for a bulk operation on a list to finish.
+	operationPollInterval = time.Second
+	operationPollAttempts = 30
+)
+
+// IPList is an account-level list of IP addresses that WAF rules refer to.
+type IPList struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// ListItem is an entry of an IP List.
+type ListItem struct {
+	ID      string `json:"id,omitempty"`
+	IP      string `json:"ip"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// operation refers to the asynchronous bulk operation that applies a change
+// to list items.
+type operation struct {
+	OperationID string `json:"operation_id"`
+}
+
+type bulkOperation struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+// listID returns the ID of the IP List named name, caching the lists of
+// the account.
+func (p *CloudflareProvider) listID(name string) (string, error) {
+	if id, ok := p.lists[name]; ok {
+		return id, nil
+	}
+
+	var lists []IPList
+	if err := p.request("GET", "/accounts/"+p.AccountID+"/rules/lists", nil, &lists); err != nil {
+		return "", fmt.Errorf("failed to fetch IP lists: %w", err)
+	}
+	p.lists = make(map[string]string, len(lists))
+	for _, list := range lists {
+		if list.Kind == "ip" {
+			p.lists[list.Name] = list.ID
+		}
+	}
+
+	id, ok := p.lists[name]
+	if !ok {
+		return "", providers.Permanent(fmt.Errorf("no IP list named %s found in account %s", name, p.AccountID))
+	}
+	return id, nil
+}
+
+// fetchListItems returns every item of the list, following the cursors the
+// API pages items with.
+func (p *CloudflareProvider) fetchListItems(listID string) ([]ListItem, error) {
+	var items []ListItem
+	query := url.Values{"per_page": {"500"}}
+	for {
+		envelope, err := p.send("GET", "/accounts/"+p.AccountID+"/rules/lists/"+listID+"/items?"+query.Encode(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch IP list items: %w", err)
+		}
+		var page []ListItem
+		if err := json.Unmarshal(envelope.Result, &page); err != nil {
+			return nil, fmt.Errorf("failed to parse response: %v", err)
+		}
+		items = append(items, page...)
+		if envelope.ResultInfo == nil || envelope.ResultInfo.Cursors == nil || envelope.ResultInfo.Cursors.After == "" {
+			return items, nil
+		}
+		query.Set("cursor", envelope.ResultInfo.Cursors.After)
+	}
+}
+
+func (p *CloudflareProvider) AddListItems(listID string, items []ListItem) error {
+	var pending operation
+	if err := p.request("POST", "/accounts/"+p.AccountID+"/rules/lists/"+listID+"/items", items, &pending); err != nil {
+		return fmt.Errorf("failed to add IP list items: %w", err)
+	}
+	return p.waitForOperation(pending.OperationID)
+}
+
+func (p *CloudflareProvider) DeleteListItems(listID string, items []ListItem) error {
+	type itemID struct {
+		ID string `json:"id"`
+	}
+	body := struct {
+		Items []itemID `json:"items"`
+	}{}
+	for _, item := range items {
+		body.Items = append(body.Items, itemID{item.ID})
+	}
+
+	var pending operation
+	if err := p.request("DELETE", "/accounts/"+p.AccountID+"/rules/lists/"+listID+"/items", body, &pending); err != nil {
+		return fmt.Errorf("failed to delete IP list items: %w", err)
+	}
+	return p.waitForOperation(pending.OperationID)
+}
+
+// waitForOperation polls a bulk operation on a list until it completed.
+// Changes to list items are applied asynchronously.
+func (p *CloudflareProvider) waitForOperation(operationID string) error {
+	if operationID == "" {
+		return nil
+	}
+	for attempt := 0; attempt < operationPollAttempts; attempt++ {
+		var status bulkOperation
+		if err := p.request("GET", "/accounts/"+p.AccountID+"/rules/lists/bulk_operations/"+operationID, nil, &status); err != nil {
+			return fmt.Errorf("failed to fetch IP list operation: %w", err)
+		}
+		switch status.Status {
+		case "completed":
+			return nil
+		case "failed":
+			return fmt.Errorf("IP list operation %s failed: %s", operationID, status.Error)
+		}
+		time.Sleep(operationPollInterval)
+	}
+	return fmt.Errorf("IP list operation %s did not complete after %s", operationID, operationPollInterval*operationPollAttempts)
+}
+
+// listEntry returns the list item value for an address. Lists take IPv6
+// only as prefixes of at most /64, so the address's /64 is used.
+func listEntry(address string) (string, error) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return "", fmt.Errorf("invalid IP address %q", address)
+	}
+	if ip.To4() != nil {
+		return ip.String(), nil
+	}
+	prefix := net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}
+	return prefix.String(), nil
+}
+
+// commitListItem replaces the list item carrying the record's comment and
+// address family with the record's address. The new item is added before
+// the old one is deleted, so rules never see the list without it.
+func (p *CloudflareProvider) commitListItem(record providers.DNSRecord) (providers.Action, error) {
+	if p.AccountID == "" {
+		return "", providers.Permanent(fmt.Errorf("IP list %s requires accountId", record.Name))
+	}
+	entry, err := listEntry(record.Content)
+	if err != nil {
+		return "", err
+	}
+	comment := record.Comment
+	if comment == "" {
+		return "", providers.Permanent(fmt.Errorf("IP list %s requires a comment identifying the entry of this site", record.Name))
+	}
+
+	listID, err := p.listID(record.Name)
+	if err != nil {
+		return "", err
+	}
+	items, err := p.fetchListItems(listID)
+	if err != nil {
+		return "", err
+	}
+
+	ipv6 := strings.Contains(entry, ":")
+	var current bool
+	var stale []ListItem
+	for _, item := range items {
+		if item.Comment != comment || strings.Contains(item.IP, ":") != ipv6 {
+			continue
+		}
+		if item.IP == entry && !current {
+			current = true
+			continue
+		}
+		stale = append(stale, item)
+	}
+
+	action := providers.ActionUnchanged
+	if !current {
+		if err := p.AddListItems(listID, []ListItem{{IP: entry, Comment: comment}}); err != nil {
+			return "", err
+		}
+		action = providers.ActionCreated
+	}
+	var old []string
+	if len(stale) > 0 {
+		if err := p.DeleteListItems(listID, stale); err != nil {
+			return "", err
+		}
+		for _, item := range stale {
+			old = append(old, item.IP)
+		}
+		action = providers.ActionUpdated
+	}
+
+	logger := providers.ActionLogger(providerName, record, action, strings.Join(old, ",")).WithField("list", record.Name)
+	switch action {
+	case providers.ActionCreated:
+		logger.Infof("Added IP list item: %s -> %s (comment: %s)", record.Name, entry, comment)
+	case providers.ActionUpdated:
+		logger.Infof("Replaced IP list item: %s %s -> %s (comment: %s)", record.Name, strings.Join(old, ", "), entry, comment)
+	default:
+		logger.Infof("Already up-to-date: %s -> %s (comment: %s)", record.Name, entry, comment)
+	}
+	return action, nil
+}
@@ -0,0 +1,101 @@
+package cloudflare
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"cfddns/providers"
+)
+
+type Zone struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Permissions lists what the credentials may do in the zone
+	Permissions []string `json:"permissions,omitempty"`
+	Account     struct {
+		ID string `json:"id"`
+	} `json:"account"`
+}
+
+// zoneFor returns the ID of the zone recordName belongs to: zoneId when it
+// is set, otherwise the zone named by zone, or else the zone with the
+// longest name recordName ends in among the zones the credentials can list.
+func (p *CloudflareProvider) zoneFor(recordName string) (string, error) {
+	if p.ZoneID != "" {
+		return p.ZoneID, nil
+	}
+	if p.ZoneName != "" {
+		return p.fetchZoneID()
+	}
+
+	if zoneID := p.matchZone(recordName); zoneID != "" {
+		return zoneID, nil
+	}
+	// List the zones when they aren't cached yet, or again to pick up a zone
+	// added since
+	if err := p.listZones(); err != nil {
+		return "", err
+	}
+	if zoneID := p.matchZone(recordName); zoneID != "" {
+		return zoneID, nil
+	}
+	return "", fmt.Errorf("no zone found for %s among the %d zones the credentials can access; set zone or zoneId", recordName, len(p.zones))
+}
+
+func (p *CloudflareProvider) fetchZoneID() (string, error) {
+	var zones []Zone
+	if err := p.request("GET", "/zones?"+url.Values{"name": {p.ZoneName}}.Encode(), nil, &zones); err != nil {
+		return "", fmt.Errorf("failed to fetch zone ID for domain %s: %w", p.ZoneName, err)
+	}
+	if len(zones) == 0 {
+		return "", providers.Permanent(fmt.Errorf("zone %s not found; check the zone name and that the API token has Zone:Read permission for it", p.ZoneName))
+	}
+
+	p.ZoneID = zones[0].ID
+	return p.ZoneID, nil
+}
+
+func (p *CloudflareProvider) listZones() error {
+	var zones []Zone
+	err := p.list("/zones", url.Values{}, func(result json.RawMessage) error {
+		var page []Zone
+		if err := json.Unmarshal(result, &page); err != nil {
+			return err
+		}
+		zones = append(zones, page...)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list zones: %w", err)
+	}
+	if len(zones) == 0 {
+		return providers.Permanent(fmt.Errorf("the credentials cannot access any zone; the API token needs Zone:Read permission"))
+	}
+
+	p.zones = zones
+	return nil
+}
+
+// matchZone returns the ID of the cached zone with the longest name that
+// recordName is part of.
+func (p *CloudflareProvider) matchZone(recordName string) string {
+	name := normalizeName(recordName)
+
+	var match Zone
+	for _, zone := range p.zones {
+		zoneName := normalizeName(zone.Name)
+		if name != zoneName && !strings.HasSuffix(name, "."+zoneName) {
+			continue
+		}
+		if len(zoneName) > len(match.Name) {
+			match = Zone{ID: zone.ID, Name: zoneName}
+		}
+	}
+	return match.ID
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
@@ -2,18 +2,22 @@ package digitalocean
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"cfddns/providers"
 
 	"github.com/digitalocean/godo"
-	"github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
 )
 
+const providerName = "digitalocean"
+
 type DigitalOceanProvider struct {
 	APIToken string
 	Domain   string
+	Limiter  *providers.Limiter
 }
 
 func (p *DigitalOceanProvider) getClient() *godo.Client {
@@ -22,14 +26,37 @@ func (p *DigitalOceanProvider) getClient() *godo.Client {
 	return godo.NewClient(oauthClient)
 }
 
-func (p *DigitalOceanProvider) CommitRecord(record providers.DNSRecord) error {
+// observeRate holds back further requests once DigitalOcean reports the
+// request quota as used up.
+func (p *DigitalOceanProvider) observeRate(resp *godo.Response) {
+	if resp != nil && resp.Rate.Limit > 0 && resp.Rate.Remaining == 0 {
+		p.Limiter.Backoff(time.Until(resp.Rate.Reset.Time))
+	}
+}
+
+// wrapError adds context to err and marks it permanent when DigitalOcean
+// rejected the token.
+func wrapError(message string, err error) error {
+	wrapped := fmt.Errorf("%s: %v", message, err)
+	var errResp *godo.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response != nil && providers.IsPermanentStatus(errResp.Response.StatusCode) {
+		return providers.Permanent(wrapped)
+	}
+	return wrapped
+}
+
+func (p *DigitalOceanProvider) CommitRecord(record providers.DNSRecord) (providers.Action, error) {
 	client := p.getClient()
 	ctx := context.Background()
 
 	// List existing records to check if the record exists
-	records, _, err := client.Domains.Records(ctx, p.Domain, nil)
+	if err := p.Limiter.Wait(); err != nil {
+		return "", err
+	}
+	records, resp, err := client.Domains.Records(ctx, p.Domain, nil)
+	p.observeRate(resp)
 	if err != nil {
-		return fmt.Errorf("failed to list DNS records: %v", err)
+		return "", wrapError("failed to list DNS records", err)
 	}
 
 	var existingRecord *godo.DomainRecord
@@ -49,28 +76,37 @@ func (p *DigitalOceanProvider) CommitRecord(record providers.DNSRecord) error {
 				Data: record.Content,
 				TTL:  record.TTL,
 			}
-			_, _, err := client.Domains.EditRecord(ctx, p.Domain, existingRecord.ID, editRequest)
+			if err := p.Limiter.Wait(); err != nil {
+				return "", err
+			}
+			_, resp, err := client.Domains.EditRecord(ctx, p.Domain, existingRecord.ID, editRequest)
+			p.observeRate(resp)
 			if err != nil {
-				return fmt.Errorf("failed to update DNS record: %v", err)
+				return "", wrapError("failed to update DNS record", err)
 			}
-			logrus.Infof("Updated DNS record: %s -> %s (TTL: %d)", record.Name, record.Content, record.TTL)
-		} else {
-			logrus.Debugf("DNS record already up-to-date: %s -> %s (TTL: %d)", record.Name, record.Content, record.TTL)
-		}
-	} else {
-		// Create a new record
-		createRequest := &godo.DomainRecordEditRequest{
-			Type: record.Type,
-			Name: record.Name,
-			Data: record.Content,
-			TTL:  record.TTL,
+			providers.ActionLogger(providerName, record, providers.ActionUpdated, existingRecord.Data).Infof("Updated DNS record: %s -> %s (TTL: %d)", record.Name, record.Content, record.TTL)
+			return providers.ActionUpdated, nil
 		}
-		_, _, err := client.Domains.CreateRecord(ctx, p.Domain, createRequest)
-		if err != nil {
-			return fmt.Errorf("failed to create DNS record: %v", err)
-		}
-		logrus.Infof("Created new DNS record: %s -> %s (TTL: %d)", record.Name, record.Content, record.TTL)
+		providers.ActionLogger(providerName, record, providers.ActionUnchanged, existingRecord.Data).Debugf("DNS record already up-to-date: %s -> %s (TTL: %d)", record.Name, record.Content, record.TTL)
+		return providers.ActionUnchanged, nil
+	}
+
+	// Create a new record
+	createRequest := &godo.DomainRecordEditRequest{
+		Type: record.Type,
+		Name: record.Name,
+		Data: record.Content,
+		TTL:  record.TTL,
+	}
+	if err := p.Limiter.Wait(); err != nil {
+		return "", err
+	}
+	_, resp, err = client.Domains.CreateRecord(ctx, p.Domain, createRequest)
+	p.observeRate(resp)
+	if err != nil {
+		return "", wrapError("failed to create DNS record", err)
 	}
+	providers.ActionLogger(providerName, record, providers.ActionCreated, "").Infof("Created new DNS record: %s -> %s (TTL: %d)", record.Name, record.Content, record.TTL)
 
-	return nil
+	return providers.ActionCreated, nil
 }
@@ -6,15 +6,17 @@ import (
 	"io"
 	"net/http"
 	"strings"
-
-	"github.com/sirupsen/logrus"
+	"time"
 )
 
+const providerName = "duckdns"
+
 type DuckDNSProvider struct {
-	Token string
+	Token   string
+	Limiter *providers.Limiter
 }
 
-func (p *DuckDNSProvider) CommitRecord(record providers.DNSRecord) error {
+func (p *DuckDNSProvider) CommitRecord(record providers.DNSRecord) (providers.Action, error) {
 	subdomain := record.Name
 	// Remove '.duckdns.org' if present
 	if strings.HasSuffix(subdomain, ".duckdns.org") {
@@ -40,20 +42,27 @@ func (p *DuckDNSProvider) CommitRecord(record providers.DNSRecord) error {
 			record.Content,
 		)
 	} else {
-		return fmt.Errorf("unsupported record type: %s", record.Type)
+		return "", fmt.Errorf("unsupported record type: %s", record.Type)
 	}
 
+	if err := p.Limiter.Wait(); err != nil {
+		return "", err
+	}
 	resp, err := http.Get(endpoint)
 	if err != nil {
-		return fmt.Errorf("failed to update DNS record: %v", err)
+		return "", fmt.Errorf("failed to update DNS record: %v", err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		p.Limiter.Backoff(providers.RetryAfter(resp, time.Minute))
+		return "", fmt.Errorf("rate limited by %s (status code: %d)", providerName, resp.StatusCode)
+	}
 
 	body, _ := io.ReadAll(resp.Body)
 	if string(body) != "OK" {
-		return fmt.Errorf("failed to update DNS record, response: %s", string(body))
+		return "", fmt.Errorf("failed to update DNS record, response: %s", string(body))
 	}
 
-	logrus.Infof("Updated DuckDNS record %s -> %s (%s)", record.Name, record.Content, record.Type)
-	return nil
+	providers.ActionLogger(providerName, record, providers.ActionUpdated, "").Infof("Updated DuckDNS record %s -> %s (%s)", record.Name, record.Content, record.Type)
+	return providers.ActionUpdated, nil
 }
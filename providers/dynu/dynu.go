@@ -8,13 +8,15 @@ import (
 	"io"
 	"net/http"
 	"strings"
-
-	"github.com/sirupsen/logrus"
+	"time"
 )
 
+const providerName = "dynu"
+
 type DynuProvider struct {
 	Username string
 	Password string // password to be hashed using MD5
+	Limiter  *providers.Limiter
 }
 
 func (p *DynuProvider) md5Hash(input string) string {
@@ -22,7 +24,7 @@ func (p *DynuProvider) md5Hash(input string) string {
 	return hex.EncodeToString(hash[:])
 }
 
-func (p *DynuProvider) CommitRecord(record providers.DNSRecord) error {
+func (p *DynuProvider) CommitRecord(record providers.DNSRecord) (providers.Action, error) {
 	// Hash the password using MD5
 	hashedPassword := p.md5Hash(p.Password)
 
@@ -32,25 +34,37 @@ func (p *DynuProvider) CommitRecord(record providers.DNSRecord) error {
 	} else if record.Type == "AAAA" {
 		endpoint = fmt.Sprintf("http://api.dynu.com/nic/update?myipv6=%s&username=%s&password=%s", record.Content, p.Username, hashedPassword)
 	} else {
-		return fmt.Errorf("unsupported record type: %s", record.Type)
+		return "", fmt.Errorf("unsupported record type: %s", record.Type)
 	}
 
+	if err := p.Limiter.Wait(); err != nil {
+		return "", err
+	}
 	resp, err := http.Get(endpoint)
 	if err != nil {
-		return fmt.Errorf("failed to update DNS record: %v", err)
+		return "", fmt.Errorf("failed to update DNS record: %v", err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		p.Limiter.Backoff(providers.RetryAfter(resp, time.Minute))
+		return "", fmt.Errorf("rate limited by %s (status code: %d)", providerName, resp.StatusCode)
+	}
 
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read HTTP response: %v", err)
+		return "", fmt.Errorf("failed to read HTTP response: %v", err)
 	}
 	body := string(bodyBytes)
 
-	if strings.Contains(body, "good") || strings.Contains(body, "nochg") {
-		logrus.Infof("Updated Dynu record %s -> %s (%s)", record.Name, record.Content, record.Type)
-		return nil
+	if strings.Contains(body, "good") {
+		providers.ActionLogger(providerName, record, providers.ActionUpdated, "").Infof("Updated Dynu record %s -> %s (%s)", record.Name, record.Content, record.Type)
+		return providers.ActionUpdated, nil
+	} else if strings.Contains(body, "nochg") {
+		providers.ActionLogger(providerName, record, providers.ActionUnchanged, "").Infof("Dynu record already up-to-date: %s -> %s (%s)", record.Name, record.Content, record.Type)
+		return providers.ActionUnchanged, nil
+	} else if strings.Contains(body, "badauth") || strings.Contains(body, "911") {
+		return "", providers.Permanent(fmt.Errorf("Dynu rejected the update, response: %s", body))
 	} else {
-		return fmt.Errorf("failed to update Dynu record, response: %s", body)
+		return "", fmt.Errorf("failed to update Dynu record, response: %s", body)
 	}
 }
@@ -0,0 +1,37 @@
+package providers
+
+import "errors"
+
+// PermanentError marks a failure that retrying will not fix, such as revoked
+// credentials or a missing permission.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent marks err as a permanent failure.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether err, or any error it wraps, is permanent.
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
+
+// IsPermanentStatus reports whether an HTTP status code means the request
+// was rejected for its credentials.
+func IsPermanentStatus(code int) bool {
+	return code == 401 || code == 403
+}
@@ -6,16 +6,18 @@ import (
 	"io"
 	"net/http"
 	"strings"
-
-	"github.com/sirupsen/logrus"
+	"time"
 )
 
+const providerName = "freedns"
+
 type FreeDNSProvider struct {
+	Limiter *providers.Limiter
 }
 
-func (p *FreeDNSProvider) CommitRecord(record providers.DNSRecord) error {
+func (p *FreeDNSProvider) CommitRecord(record providers.DNSRecord) (providers.Action, error) {
 	if record.UpdateToken == "" {
-		return fmt.Errorf("UpdateToken is required for FreeDNS record")
+		return "", fmt.Errorf("UpdateToken is required for FreeDNS record")
 	}
 
 	endpoint := fmt.Sprintf("https://freedns.afraid.org/dynamic/update.php?%s", record.UpdateToken)
@@ -24,29 +26,39 @@ func (p *FreeDNSProvider) CommitRecord(record providers.DNSRecord) error {
 		if record.Type == "A" || record.Type == "AAAA" {
 			endpoint += "&address=" + record.Content
 		} else {
-			return fmt.Errorf("unsupported record type: %s", record.Type)
+			return "", fmt.Errorf("unsupported record type: %s", record.Type)
 		}
 	}
 
+	if err := p.Limiter.Wait(); err != nil {
+		return "", err
+	}
 	resp, err := http.Get(endpoint)
 	if err != nil {
-		return fmt.Errorf("failed to update FreeDNS record: %v", err)
+		return "", fmt.Errorf("failed to update FreeDNS record: %v", err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		p.Limiter.Backoff(providers.RetryAfter(resp, time.Minute))
+		return "", fmt.Errorf("rate limited by %s (status code: %d)", providerName, resp.StatusCode)
+	}
 
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response from FreeDNS: %v", err)
+		return "", fmt.Errorf("failed to read response from FreeDNS: %v", err)
 	}
 	body := string(bodyBytes)
 
-	if strings.Contains(body, "has not changed.") || strings.Contains(body, "Updated") {
-		logrus.Infof("Updated FreeDNS record %s -> %s (%s)", record.Name, record.Content, record.Type)
-		return nil
+	if strings.Contains(body, "has not changed.") {
+		providers.ActionLogger(providerName, record, providers.ActionUnchanged, "").Infof("FreeDNS record already up-to-date: %s -> %s (%s)", record.Name, record.Content, record.Type)
+		return providers.ActionUnchanged, nil
+	} else if strings.Contains(body, "Updated") {
+		providers.ActionLogger(providerName, record, providers.ActionUpdated, "").Infof("Updated FreeDNS record %s -> %s (%s)", record.Name, record.Content, record.Type)
+		return providers.ActionUpdated, nil
 	} else if strings.Contains(body, "ERROR") {
-		return fmt.Errorf("failed to update FreeDNS record, response: %s", body)
+		return "", fmt.Errorf("failed to update FreeDNS record, response: %s", body)
 	} else {
-		logrus.Warnf("Unexpected response from FreeDNS: %s", body)
-		return nil
+		providers.Logger(providerName, record).Warnf("Unexpected response from FreeDNS: %s", body)
+		return providers.ActionUpdated, nil
 	}
 }
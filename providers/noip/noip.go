@@ -7,16 +7,37 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
+)
 
-	"github.com/sirupsen/logrus"
+const (
+	providerName = "noip"
+	// serverErrorBackoff is the pause No-IP requires after a 911 response.
+	serverErrorBackoff = 30 * time.Minute
 )
 
 type NoIPProvider struct {
 	Username string
 	Password string
+	Limiter  *providers.Limiter
+
+	mu sync.Mutex
+	// committed holds the last address No-IP accepted per record. No-IP
+	// treats repeated nochg updates as abuse, so they are not sent again.
+	committed map[string]string
 }
 
-func (p *NoIPProvider) CommitRecord(record providers.DNSRecord) error {
+func (p *NoIPProvider) CommitRecord(record providers.DNSRecord) (providers.Action, error) {
+	key := record.Type + "/" + record.Name
+	p.mu.Lock()
+	last := p.committed[key]
+	p.mu.Unlock()
+	if last == record.Content {
+		providers.ActionLogger(providerName, record, providers.ActionUnchanged, last).Debugf("No-IP record %s was already set to %s, not sending another update", record.Name, record.Content)
+		return providers.ActionUnchanged, nil
+	}
+
 	var endpoint string
 
 	if record.Type == "A" {
@@ -26,12 +47,12 @@ func (p *NoIPProvider) CommitRecord(record providers.DNSRecord) error {
 		// Update IPv6 address
 		endpoint = fmt.Sprintf("https://dynupdate.no-ip.com/nic/update?hostname=%s&myipv6=%s", record.Name, record.Content)
 	} else {
-		return fmt.Errorf("unsupported record type: %s", record.Type)
+		return "", fmt.Errorf("unsupported record type: %s", record.Type)
 	}
 
 	req, err := http.NewRequest("GET", endpoint, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create HTTP request: %v", err)
+		return "", fmt.Errorf("failed to create HTTP request: %v", err)
 	}
 
 	// Set Basic Auth header
@@ -40,23 +61,56 @@ func (p *NoIPProvider) CommitRecord(record providers.DNSRecord) error {
 	req.Header.Set("Authorization", "Basic "+encodedAuth)
 	req.Header.Set("User-Agent", "cfddns/1.0 (root@dnim.dev)")
 
+	if err := p.Limiter.Wait(); err != nil {
+		return "", err
+	}
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send HTTP request: %v", err)
+		return "", fmt.Errorf("failed to send HTTP request: %v", err)
 	}
 	defer resp.Body.Close()
 
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read HTTP response: %v", err)
+		return "", fmt.Errorf("failed to read HTTP response: %v", err)
 	}
 	body := string(bodyBytes)
 
-	if strings.HasPrefix(body, "good") || strings.HasPrefix(body, "nochg") {
-		logrus.Infof("Updated No-IP record %s -> %s (%s)", record.Name, record.Content, record.Type)
-		return nil
+	if strings.HasPrefix(body, "good") {
+		p.remember(key, record.Content)
+		providers.ActionLogger(providerName, record, providers.ActionUpdated, "").Infof("Updated No-IP record %s -> %s (%s)", record.Name, record.Content, record.Type)
+		return providers.ActionUpdated, nil
+	} else if strings.HasPrefix(body, "nochg") {
+		p.remember(key, record.Content)
+		providers.ActionLogger(providerName, record, providers.ActionUnchanged, "").Infof("No-IP record already up-to-date: %s -> %s (%s)", record.Name, record.Content, record.Type)
+		return providers.ActionUnchanged, nil
+	} else if strings.HasPrefix(body, "911") {
+		p.Limiter.Backoff(serverErrorBackoff)
+		return "", providers.Permanent(fmt.Errorf("No-IP server error, pausing updates for %s, response: %s", serverErrorBackoff, body))
+	} else if isRejected(body) {
+		return "", providers.Permanent(fmt.Errorf("No-IP rejected the update, response: %s", body))
 	} else {
-		return fmt.Errorf("failed to update No-IP record, response: %s", body)
+		return "", fmt.Errorf("failed to update No-IP record, response: %s", body)
+	}
+}
+
+// isRejected reports whether body is one of the responses No-IP sends for
+// updates that must not be retried without fixing the configuration.
+func isRejected(body string) bool {
+	for _, code := range []string{"badauth", "nohost", "badagent", "abuse", "!donator"} {
+		if strings.HasPrefix(body, code) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *NoIPProvider) remember(key, content string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.committed == nil {
+		p.committed = make(map[string]string)
 	}
+	p.committed[key] = content
 }
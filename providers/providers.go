@@ -1,5 +1,7 @@
 package providers
 
+import "github.com/sirupsen/logrus"
+
 type DNSRecord struct {
 	Name        string
 	Type        string
@@ -7,8 +9,74 @@ type DNSRecord struct {
 	TTL         int
 	Proxied     bool
 	UpdateToken string
+	// Duplicates is the Cloudflare policy for several existing records with
+	// the same name and type: single, first or error.
+	Duplicates string
+	// Adopt lets Cloudflare take over an existing record that lacks the
+	// ownership marker, stamping the marker on it.
+	Adopt bool
+	// Target is what a Cloudflare record updates: a DNS record, or with
+	// ipList an entry of the IP List named by Name, identified by Comment.
+	Target  string
+	Comment string
+	// Route53 routing policy: the set identifier, and one of weight,
+	// failover role (PRIMARY or SECONDARY) or latency region
+	SetIdentifier string
+	Weight        *int
+	Failover      string
+	Region        string
+	HealthCheckID string
 }
 
+// Action describes what CommitRecord did to a record.
+type Action string
+
+const (
+	ActionCreated   Action = "created"
+	ActionUpdated   Action = "updated"
+	ActionUnchanged Action = "unchanged"
+)
+
 type Provider interface {
-	CommitRecord(record DNSRecord) error
+	CommitRecord(record DNSRecord) (Action, error)
+}
+
+// CommitResult is the outcome of committing one of several records.
+type CommitResult struct {
+	Action Action
+	Err    error
+}
+
+// BatchCommitter is implemented by providers that commit several records
+// with fewer requests than one at a time. Results are in the order of
+// records.
+type BatchCommitter interface {
+	CommitRecords(records []DNSRecord) []CommitResult
+}
+
+// Checker is implemented by providers that can verify their credentials and
+// access to records without changing anything.
+type Checker interface {
+	Check(records []DNSRecord) error
+}
+
+// Logger returns a log entry carrying the fields every provider attaches to
+// its record log lines, so they can be indexed without parsing the message.
+func Logger(provider string, record DNSRecord) *logrus.Entry {
+	return logrus.WithFields(logrus.Fields{
+		"provider": provider,
+		"record":   record.Name,
+		"type":     record.Type,
+		"new":      record.Content,
+	})
+}
+
+// ActionLogger extends Logger with the outcome of a commit in the action field,
+// and old when the previous value is known.
+func ActionLogger(provider string, record DNSRecord, action Action, old string) *logrus.Entry {
+	entry := Logger(provider, record).WithField("action", string(action))
+	if old != "" {
+		entry = entry.WithField("old", old)
+	}
+	return entry
 }
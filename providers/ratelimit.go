@@ -0,0 +1,79 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// maxBackoffWait is the longest Wait sleeps for a server-imposed pause.
+// Longer pauses fail the request instead of stalling the whole update run.
+const maxBackoffWait = 30 * time.Second
+
+// Limiter is a token bucket shared by every request a provider instance
+// sends. Backoff pauses it when the server reports that a limit was hit.
+// A nil Limiter never blocks.
+type Limiter struct {
+	limiter *rate.Limiter
+
+	mu           sync.Mutex
+	blockedUntil time.Time
+}
+
+// NewLimiter allows perSecond requests per second with bursts of up to burst.
+func NewLimiter(perSecond float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{limiter: rate.NewLimiter(rate.Limit(perSecond), burst)}
+}
+
+// Wait blocks until the next request may be sent.
+func (l *Limiter) Wait() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	pause := time.Until(l.blockedUntil)
+	l.mu.Unlock()
+	if pause > maxBackoffWait {
+		return fmt.Errorf("rate limited by the server until %s", l.blockedUntil.Format(time.RFC3339))
+	}
+	if pause > 0 {
+		time.Sleep(pause)
+	}
+
+	return l.limiter.Wait(context.Background())
+}
+
+// Backoff holds back all requests for d.
+func (l *Limiter) Backoff(d time.Duration) {
+	if l == nil || d <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.blockedUntil) {
+		l.blockedUntil = until
+	}
+}
+
+// RetryAfter returns the pause requested by the Retry-After header of resp,
+// given either in seconds or as an HTTP date, or fallback if there is none.
+func RetryAfter(resp *http.Response, fallback time.Duration) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return fallback
+}
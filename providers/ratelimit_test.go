@@ -0,0 +1,35 @@
+package providers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+	}{
+		{"seconds", "120", 2 * time.Minute},
+		{"zero seconds", "0", 0},
+		{"http date", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), time.Hour},
+		{"missing", "", 5 * time.Second},
+		{"malformed", "soon", 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if tt.header != "" {
+				resp.Header.Set("Retry-After", tt.header)
+			}
+			got := RetryAfter(resp, 5*time.Second)
+			// Dates have a resolution of one second
+			if got > tt.want || got < tt.want-time.Second {
+				t.Errorf("RetryAfter(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,84 +1,210 @@
 package route53
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"cfddns/providers"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/route53"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	providerName = "route53"
+	// throttleBackoff is how long all requests are held back after Route53
+	// reports throttling, on top of the SDK's own retry delay.
+	throttleBackoff = time.Second
+	roleSessionName = "cfddns"
+	// syncPollInterval and syncTimeout bound waiting for changes to be
+	// propagated when WaitForSync is set.
+	syncPollInterval = 5 * time.Second
+	syncTimeout      = 2 * time.Minute
+)
+
+// Route53Provider authenticates with the static AccessKeyID and
+// SecretAccessKey when they are set, and otherwise with the default AWS
+// credential chain: environment variables, the shared config and
+// credentials files (using Profile), web identity tokens and the EC2
+// instance role. With RoleARN set, those credentials assume the role.
 type Route53Provider struct {
 	ZoneName        string
 	ZoneID          string
 	Region          string
 	AccessKeyID     string
 	SecretAccessKey string
+	Profile         string
+	RoleARN         string
+	ExternalID      string
+	// PrivateZone selects the private hosted zone named ZoneName rather than
+	// the public one.
+	PrivateZone bool
+	// WaitForSync waits until changes have reached all Route53 name servers.
+	WaitForSync bool
+	Limiter     *providers.Limiter
+
+	// sess is kept so assumed role credentials are reused until they expire
+	sess *session.Session
 }
 
 func (p *Route53Provider) getSession() (*session.Session, error) {
-	creds := credentials.NewStaticCredentials(p.AccessKeyID, p.SecretAccessKey, "")
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(p.Region),
-		Credentials: creds,
+	if p.sess != nil {
+		return p.sess, nil
+	}
+
+	awsConfig := aws.Config{Region: aws.String(p.Region)}
+	if p.AccessKeyID != "" {
+		awsConfig.Credentials = credentials.NewStaticCredentials(p.AccessKeyID, p.SecretAccessKey, "")
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            awsConfig,
+		Profile:           p.Profile,
+		SharedConfigState: session.SharedConfigEnable,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create AWS session: %v", err)
 	}
+
+	if p.RoleARN != "" {
+		creds := stscreds.NewCredentials(sess, p.RoleARN, func(provider *stscreds.AssumeRoleProvider) {
+			provider.RoleSessionName = roleSessionName
+			if p.ExternalID != "" {
+				provider.ExternalID = aws.String(p.ExternalID)
+			}
+		})
+		sess = sess.Copy(&aws.Config{Credentials: creds})
+	}
+
+	// Every attempt, including SDK retries, is signed right before it is sent,
+	// so waiting for the rate limiter here covers retries as well
+	sess.Handlers.Sign.PushFront(func(r *request.Request) {
+		if err := p.Limiter.Wait(); err != nil {
+			r.Error = err
+		}
+	})
+	sess.Handlers.Retry.PushBack(func(r *request.Request) {
+		if r.IsErrorThrottle() {
+			logrus.WithField("provider", providerName).Warnf("Route53 is throttling requests, slowing down")
+			p.Limiter.Backoff(throttleBackoff)
+		}
+	})
+	p.sess = sess
 	return sess, nil
 }
 
-func (p *Route53Provider) getZoneID() (string, error) {
+// wrapError adds context to err and marks it permanent when AWS rejected the
+// credentials or none were found.
+func wrapError(message string, err error) error {
+	wrapped := fmt.Errorf("%s: %v", message, err)
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && providers.IsPermanentStatus(reqErr.StatusCode()) {
+		return providers.Permanent(wrapped)
+	}
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == "NoCredentialProviders" {
+		return providers.Permanent(wrapped)
+	}
+	return wrapped
+}
+
+func (p *Route53Provider) getZoneID(svc *route53.Route53) (string, error) {
 	if p.ZoneID != "" {
 		return p.ZoneID, nil
 	}
 
-	sess, err := p.getSession()
+	// Public and private zones may share a name; only those of the
+	// configured visibility are considered
+	var matches []string
+	err := svc.ListHostedZonesPages(&route53.ListHostedZonesInput{}, func(page *route53.ListHostedZonesOutput, lastPage bool) bool {
+		for _, zone := range page.HostedZones {
+			private := zone.Config != nil && aws.BoolValue(zone.Config.PrivateZone)
+			if normalizeName(aws.StringValue(zone.Name)) == normalizeName(p.ZoneName) && private == p.PrivateZone {
+				matches = append(matches, strings.TrimPrefix(aws.StringValue(zone.Id), "/hostedzone/"))
+			}
+		}
+		return true
+	})
 	if err != nil {
-		return "", err
+		return "", wrapError("failed to list hosted zones", err)
 	}
 
-	svc := route53.New(sess)
+	visibility := "public"
+	if p.PrivateZone {
+		visibility = "private"
+	}
+	switch len(matches) {
+	case 0:
+		return "", providers.Permanent(fmt.Errorf("%s hosted zone for %s not found", visibility, p.ZoneName))
+	case 1:
+		p.ZoneID = matches[0]
+		return p.ZoneID, nil
+	default:
+		return "", providers.Permanent(fmt.Errorf("found %d %s hosted zones for %s (%s); set zoneId", len(matches), visibility, p.ZoneName, strings.Join(matches, ", ")))
+	}
+}
 
-	// List hosted zones
-	result, err := svc.ListHostedZones(nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to list hosted zones: %v", err)
+// fetchRecordSets returns the record sets of the zone by name and type. For
+// a single record only its own record sets are listed, otherwise the whole
+// zone is.
+func (p *Route53Provider) fetchRecordSets(svc *route53.Route53, zoneID string, records []providers.DNSRecord) (map[string][]*route53.ResourceRecordSet, error) {
+	sets := make(map[string][]*route53.ResourceRecordSet)
+	input := &route53.ListResourceRecordSetsInput{HostedZoneId: aws.String(zoneID)}
+	var only string
+	if len(records) == 1 {
+		input.StartRecordName = aws.String(records[0].Name)
+		input.StartRecordType = aws.String(records[0].Type)
+		only = recordKey(records[0].Name, records[0].Type)
 	}
 
-	// Find the correct zone by name
-	for _, zone := range result.HostedZones {
-		if strings.TrimSuffix(*zone.Name, ".") == p.ZoneName {
-			p.ZoneID = strings.TrimPrefix(*zone.Id, "/hostedzone/")
-			return p.ZoneID, nil
+	err := svc.ListResourceRecordSetsPages(input, func(page *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
+		for _, set := range page.ResourceRecordSets {
+			key := recordKey(aws.StringValue(set.Name), aws.StringValue(set.Type))
+			if only != "" && key != only {
+				return false
+			}
+			sets[key] = append(sets[key], set)
 		}
+		return true
+	})
+	if err != nil {
+		return nil, wrapError("failed to list record sets", err)
 	}
-
-	return "", fmt.Errorf("hosted zone for %s not found", p.ZoneName)
+	return sets, nil
 }
 
-func (p *Route53Provider) CommitRecord(record providers.DNSRecord) error {
-	sess, err := p.getSession()
-	if err != nil {
-		return err
-	}
+func recordKey(name, recordType string) string {
+	return normalizeName(name) + "/" + recordType
+}
 
-	svc := route53.New(sess)
+// normalizeName lowercases name and removes the trailing dot and the octal
+// escape Route53 returns for wildcards.
+func normalizeName(name string) string {
+	name = strings.ReplaceAll(name, `\052`, "*")
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
 
-	zoneID, err := p.getZoneID()
-	if err != nil {
-		return err
-	}
+// change is the record set a record should have, the action that gets it
+// there and the values it replaces.
+type change struct {
+	record providers.DNSRecord
+	set    *route53.ResourceRecordSet
+	action providers.Action
+	old    string
+}
 
-	// Prepare the record change
-	change := &route53.Change{
-		Action: aws.String("UPSERT"),
-		ResourceRecordSet: &route53.ResourceRecordSet{
+func planChange(record providers.DNSRecord, existing []*route53.ResourceRecordSet) *change {
+	c := &change{
+		record: record,
+		set: &route53.ResourceRecordSet{
 			Name: aws.String(record.Name),
 			Type: aws.String(record.Type),
 			TTL:  aws.Int64(int64(record.TTL)),
@@ -88,19 +214,192 @@ func (p *Route53Provider) CommitRecord(record providers.DNSRecord) error {
 				},
 			},
 		},
+		action: providers.ActionCreated,
+	}
+	if record.SetIdentifier != "" {
+		c.set.SetIdentifier = aws.String(record.SetIdentifier)
+	}
+	if record.Weight != nil {
+		c.set.Weight = aws.Int64(int64(*record.Weight))
+	}
+	if record.Failover != "" {
+		c.set.Failover = aws.String(record.Failover)
+	}
+	if record.Region != "" {
+		c.set.Region = aws.String(record.Region)
+	}
+	if record.HealthCheckID != "" {
+		c.set.HealthCheckId = aws.String(record.HealthCheckID)
+	}
+
+	// Record sets with a routing policy share the name and type, and are
+	// told apart by their set identifier
+	for _, current := range existing {
+		if aws.StringValue(current.SetIdentifier) != record.SetIdentifier {
+			continue
+		}
+		var values []string
+		for _, value := range current.ResourceRecords {
+			values = append(values, aws.StringValue(value.Value))
+		}
+		c.old = strings.Join(values, ",")
+		if c.old == record.Content && aws.Int64Value(current.TTL) == int64(record.TTL) && current.AliasTarget == nil && sameRouting(current, c.set) {
+			c.action = providers.ActionUnchanged
+		} else {
+			c.action = providers.ActionUpdated
+		}
+		break
+	}
+	return c
+}
+
+// sameRouting reports whether two record sets have the same routing policy
+// and health check.
+func sameRouting(a, b *route53.ResourceRecordSet) bool {
+	return aws.Int64Value(a.Weight) == aws.Int64Value(b.Weight) && (a.Weight == nil) == (b.Weight == nil) &&
+		aws.StringValue(a.Failover) == aws.StringValue(b.Failover) &&
+		aws.StringValue(a.Region) == aws.StringValue(b.Region) &&
+		aws.StringValue(a.HealthCheckId) == aws.StringValue(b.HealthCheckId)
+}
+
+func logChange(c *change) {
+	logger := providers.ActionLogger(providerName, c.record, c.action, c.old)
+	if c.record.SetIdentifier != "" {
+		logger = logger.WithField("setIdentifier", c.record.SetIdentifier)
+	}
+	switch c.action {
+	case providers.ActionCreated:
+		logger.Infof("Created new DNS record: %s -> %s (TTL: %d)", c.record.Name, c.record.Content, c.record.TTL)
+	case providers.ActionUpdated:
+		logger.Infof("Updated DNS record: %s -> %s (TTL: %d)", c.record.Name, c.record.Content, c.record.TTL)
+	default:
+		logger.Infof("Already up-to-date: %s -> %s (TTL: %d)", c.record.Name, c.record.Content, c.record.TTL)
+	}
+}
+
+// applyChanges upserts the record sets of changes in one change batch and
+// returns the ID of the change.
+func (p *Route53Provider) applyChanges(svc *route53.Route53, zoneID string, changes []*change) (string, error) {
+	batch := &route53.ChangeBatch{}
+	for _, c := range changes {
+		batch.Changes = append(batch.Changes, &route53.Change{
+			Action:            aws.String(route53.ChangeActionUpsert),
+			ResourceRecordSet: c.set,
+		})
 	}
 
-	// Make the request to update the record
-	_, err = svc.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+	output, err := svc.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
 		HostedZoneId: aws.String(zoneID),
-		ChangeBatch: &route53.ChangeBatch{
-			Changes: []*route53.Change{change},
-		},
+		ChangeBatch:  batch,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to update or create record: %v", err)
+		return "", wrapError("failed to update or create record", err)
 	}
+	return aws.StringValue(output.ChangeInfo.Id), nil
+}
+
+// waitForSync waits until a change has been propagated to all Route53 name
+// servers.
+func (p *Route53Provider) waitForSync(svc *route53.Route53, changeID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), syncTimeout)
+	defer cancel()
 
-	logrus.Infof("Record %s -> %s (%s) updated/created successfully", record.Name, record.Content, record.Type)
+	err := svc.WaitUntilResourceRecordSetsChangedWithContext(ctx, &route53.GetChangeInput{Id: aws.String(changeID)}, request.WithWaiterDelay(request.ConstantWaiterDelay(syncPollInterval)))
+	if err != nil {
+		return wrapError(fmt.Sprintf("change %s was submitted but is not in sync", changeID), err)
+	}
+	logrus.WithField("provider", providerName).Debugf("Change %s is in sync", changeID)
 	return nil
 }
+
+func (p *Route53Provider) CommitRecord(record providers.DNSRecord) (providers.Action, error) {
+	result := p.CommitRecords([]providers.DNSRecord{record})[0]
+	return result.Action, result.Err
+}
+
+// CommitRecords reads the current record sets, skips records that are up to
+// date and upserts the others in one change batch. If Route53 rejects the
+// batch, the records are retried one by one.
+func (p *Route53Provider) CommitRecords(records []providers.DNSRecord) []providers.CommitResult {
+	results := make([]providers.CommitResult, len(records))
+	fail := func(err error) []providers.CommitResult {
+		for i := range results {
+			results[i].Err = err
+		}
+		return results
+	}
+
+	sess, err := p.getSession()
+	if err != nil {
+		return fail(err)
+	}
+	svc := route53.New(sess)
+
+	zoneID, err := p.getZoneID(svc)
+	if err != nil {
+		return fail(err)
+	}
+	existing, err := p.fetchRecordSets(svc, zoneID, records)
+	if err != nil {
+		return fail(err)
+	}
+
+	changes := make([]*change, len(records))
+	var writes []int
+	for i, record := range records {
+		changes[i] = planChange(record, existing[recordKey(record.Name, record.Type)])
+		if changes[i].action == providers.ActionUnchanged {
+			logChange(changes[i])
+			results[i].Action = providers.ActionUnchanged
+			continue
+		}
+		writes = append(writes, i)
+	}
+	if len(writes) == 0 {
+		return results
+	}
+
+	type submission struct {
+		changeID string
+		indexes  []int
+	}
+	var submissions []submission
+	batch := make([]*change, 0, len(writes))
+	for _, i := range writes {
+		batch = append(batch, changes[i])
+	}
+	changeID, err := p.applyChanges(svc, zoneID, batch)
+	switch {
+	case err == nil:
+		submissions = append(submissions, submission{changeID, writes})
+	case len(writes) > 1 && !providers.IsPermanent(err):
+		logrus.WithField("provider", providerName).Warnf("Updating %d DNS records one by one: %v", len(writes), err)
+		for _, i := range writes {
+			changeID, err := p.applyChanges(svc, zoneID, []*change{changes[i]})
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+			submissions = append(submissions, submission{changeID, []int{i}})
+		}
+	default:
+		for _, i := range writes {
+			results[i].Err = err
+		}
+	}
+
+	for _, submitted := range submissions {
+		if p.WaitForSync {
+			// Route53 accepted the change, so the records count as committed
+			// even if it does not reach the name servers in time
+			if err := p.waitForSync(svc, submitted.changeID); err != nil {
+				logrus.WithField("provider", providerName).WithError(err).Warnf("Records were updated, but %v", err)
+			}
+		}
+		for _, i := range submitted.indexes {
+			logChange(changes[i])
+			results[i].Action = changes[i].action
+		}
+	}
+	return results
+}
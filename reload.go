@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"cfddns/config"
+	"cfddns/notify"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// configWatchDebounce groups the burst of events editors produce when saving
+// a file into a single reload.
+const configWatchDebounce = time.Second
+
+// reloadConfig loads the configuration from the same path as cfg along with
+// the notifiers it describes. On error the caller keeps its current ones.
+func reloadConfig(cfg *config.Config) (*config.Config, *notify.Dispatcher, bool) {
+	newCfg, err := config.LoadConfig(cfg.Path)
+	if err != nil {
+		logrus.Errorf("Error reloading configuration, keeping current configuration: %v", err)
+		return nil, nil, false
+	}
+	notifier, err := notify.New(newCfg.Notifications, newCfg.Hooks)
+	if err != nil {
+		logrus.Errorf("Error reloading notifications, keeping current configuration: %v", err)
+		return nil, nil, false
+	}
+	logrus.Infof("Configuration reloaded from %s", newCfg.Path)
+	return newCfg, notifier, true
+}
+
+// changedRecords returns a copy of newCfg containing only the records that are
+// new or differ from oldCfg. A provider whose settings changed keeps all of
+// its records.
+func changedRecords(oldCfg, newCfg *config.Config) *config.Config {
+	type recordKey struct {
+		providerType  string
+		name          string
+		recordType    string
+		setIdentifier string
+	}
+
+	oldRecords := make(map[recordKey]config.DNSRecord)
+	oldSettings := make(map[recordKey]map[string]interface{})
+	for _, providerCfg := range oldCfg.Providers {
+		for _, record := range providerCfg.Records {
+			key := recordKey{providerCfg.Type, record.Name, record.Type, record.SetIdentifier}
+			oldRecords[key] = record
+			oldSettings[key] = providerCfg.Settings
+		}
+	}
+
+	diff := *newCfg
+	diff.Providers = nil
+	for _, providerCfg := range newCfg.Providers {
+		changed := providerCfg
+		changed.Records = nil
+		for _, record := range providerCfg.Records {
+			key := recordKey{providerCfg.Type, record.Name, record.Type, record.SetIdentifier}
+			oldRecord, ok := oldRecords[key]
+			if ok && reflect.DeepEqual(oldRecord, record) && reflect.DeepEqual(oldSettings[key], providerCfg.Settings) {
+				continue
+			}
+			changed.Records = append(changed.Records, record)
+		}
+		if len(changed.Records) > 0 {
+			diff.Providers = append(diff.Providers, changed)
+		}
+	}
+	return &diff
+}
+
+// watchConfig watches the configuration file and its conf.d directory and
+// sends on reload whenever one of them changes.
+func watchConfig(configPath string, reload chan<- struct{}) (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	// Watch directories rather than files so that editors replacing the file
+	// on save do not drop the watch.
+	configDir := filepath.Dir(configPath)
+	fragmentDir := config.FragmentDir(configPath)
+	if err := watcher.Add(configDir); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	if fileExists(fragmentDir) {
+		if err := watcher.Add(fragmentDir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+
+	go func() {
+		var debounce <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == filepath.Clean(configPath) ||
+					(filepath.Dir(event.Name) == fragmentDir && filepath.Ext(event.Name) == ".yml") {
+					logrus.Debugf("Configuration change detected: %s", event)
+					debounce = time.After(configWatchDebounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logrus.Warnf("Error watching configuration: %v", err)
+			case <-debounce:
+				debounce = nil
+				select {
+				case reload <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return watcher, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"cfddns/metrics"
+	"cfddns/status"
+
+	"github.com/sirupsen/logrus"
+)
+
+// startHTTPServer serves the daemon's monitoring endpoints on listen.
+func startHTTPServer(listen string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/healthz", status.HealthHandler())
+	mux.Handle("/status", status.Handler())
+
+	server := &http.Server{
+		Addr:              listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		logrus.Infof("HTTP server listening on %s", listen)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.Errorf("HTTP server error: %v", err)
+		}
+	}()
+
+	return server
+}
+
+func stopHTTPServer(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logrus.Warnf("Error shutting down HTTP server: %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package status
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// livenessGrace is how long past its expected heartbeat the daemon loop may
+// be before /healthz reports it as stuck. Updates run on the loop, so this
+// must cover a slow update cycle.
+const livenessGrace = 2 * time.Minute
+
+type RecordStatus struct {
+	Provider   string     `json:"provider"`
+	Name       string     `json:"name"`
+	Type       string     `json:"type"`
+	Value      string     `json:"value,omitempty"`
+	LastUpdate *time.Time `json:"lastUpdate,omitempty"`
+	LastError  string     `json:"lastError,omitempty"`
+	ErrorTime  *time.Time `json:"errorTime,omitempty"`
+}
+
+type Status struct {
+	IPv4      string         `json:"ipv4"`
+	IPv6      string         `json:"ipv6"`
+	Connected bool           `json:"connected"`
+	NextCheck *time.Time     `json:"nextCheck,omitempty"`
+	Records   []RecordStatus `json:"records"`
+}
+
+var (
+	mu            sync.Mutex
+	current       Status
+	records       = make(map[string]*RecordStatus)
+	nextHeartbeat time.Time
+)
+
+func recordKey(provider, name, recordType string) string {
+	return provider + "/" + name + "/" + recordType
+}
+
+// SetIP records the latest external IP for family ("ipv4" or "ipv6").
+func SetIP(family, ip string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if family == "ipv6" {
+		current.IPv6 = ip
+	} else {
+		current.IPv4 = ip
+	}
+}
+
+// SetConnected records the connectivity state tracked by the daemon.
+func SetConnected(connected bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	current.Connected = connected
+}
+
+// SetNextCheck records when the daemon will next check for an IP change.
+func SetNextCheck(next time.Time) {
+	mu.Lock()
+	defer mu.Unlock()
+	current.NextCheck = &next
+}
+
+// SetRecordResult records the outcome of committing a record. On success
+// value becomes the record's last published value and any error is cleared.
+func SetRecordResult(provider, name, recordType, value string, err error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	key := recordKey(provider, name, recordType)
+	record, ok := records[key]
+	if !ok {
+		record = &RecordStatus{Provider: provider, Name: name, Type: recordType}
+		records[key] = record
+	}
+
+	now := time.Now()
+	if err != nil {
+		record.LastError = err.Error()
+		record.ErrorTime = &now
+		return
+	}
+	record.Value = value
+	record.LastUpdate = &now
+	record.LastError = ""
+	record.ErrorTime = nil
+}
+
+// PruneRecords drops the records keep returns false for, such as records
+// removed from the configuration.
+func PruneRecords(keep func(provider, name, recordType string) bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	for key, record := range records {
+		if !keep(record.Provider, record.Name, record.Type) {
+			delete(records, key)
+		}
+	}
+}
+
+// Heartbeat tells the liveness check that the daemon loop is running and
+// expects to beat again within next.
+func Heartbeat(next time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	nextHeartbeat = time.Now().Add(next)
+}
+
+// Snapshot returns a copy of the current status.
+func Snapshot() Status {
+	mu.Lock()
+	defer mu.Unlock()
+
+	snapshot := current
+	snapshot.Records = make([]RecordStatus, 0, len(records))
+	for _, record := range records {
+		snapshot.Records = append(snapshot.Records, *record)
+	}
+	sort.Slice(snapshot.Records, func(i, j int) bool {
+		a, b := snapshot.Records[i], snapshot.Records[j]
+		return recordKey(a.Provider, a.Name, a.Type) < recordKey(b.Provider, b.Name, b.Type)
+	})
+	return snapshot
+}
+
+// HealthHandler serves the liveness probe. It fails once the daemon loop has
+// missed its heartbeat by more than livenessGrace.
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		deadline := nextHeartbeat
+		mu.Unlock()
+
+		if !deadline.IsZero() && time.Now().After(deadline.Add(livenessGrace)) {
+			http.Error(w, "daemon loop is not responding", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("ok\n"))
+	})
+}
+
+// Handler serves the current status as JSON.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(Snapshot())
+	})
+}
@@ -0,0 +1,26 @@
+package status
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPruneRecords(t *testing.T) {
+	SetRecordResult("cloudflare", "home.example.com", "A", "203.0.113.1", nil)
+	SetRecordResult("cloudflare", "old.example.com", "A", "", errors.New("failed"))
+	SetRecordResult("route53", "home.example.com", "AAAA", "2001:db8::1", nil)
+
+	PruneRecords(func(provider, name, recordType string) bool {
+		return name == "home.example.com"
+	})
+
+	snapshot := Snapshot()
+	if len(snapshot.Records) != 2 {
+		t.Fatalf("got %d records, want 2: %+v", len(snapshot.Records), snapshot.Records)
+	}
+	for _, record := range snapshot.Records {
+		if record.Name != "home.example.com" {
+			t.Errorf("record %s was not pruned", record.Name)
+		}
+	}
+}
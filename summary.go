@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"cfddns/providers"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Exit codes of a one-shot run.
+const (
+	exitOK            = 0
+	exitPartial       = 2
+	exitFailed        = 3
+	exitIPUnavailable = 4
+)
+
+// recordResult is the outcome of updating a single configured record.
+type recordResult struct {
+	Provider string
+	Name     string
+	Type     string
+	Content  string
+	Action   providers.Action
+	Err      error
+	Skipped  bool
+}
+
+// outcome returns the action taken, or failed or skipped.
+func (r recordResult) outcome() string {
+	switch {
+	case r.Skipped:
+		return "skipped"
+	case r.Err != nil:
+		return "failed"
+	default:
+		return string(r.Action)
+	}
+}
+
+// runSummary is the outcome of one update run.
+type runSummary struct {
+	IPv4    string
+	IPv6    string
+	Results []recordResult
+}
+
+func (s runSummary) counts() map[string]int {
+	counts := make(map[string]int)
+	for _, result := range s.Results {
+		counts[result.outcome()]++
+	}
+	return counts
+}
+
+func (s runSummary) log() {
+	counts := s.counts()
+	entry := logrus.WithFields(logrus.Fields{
+		"created":   counts[string(providers.ActionCreated)],
+		"updated":   counts[string(providers.ActionUpdated)],
+		"unchanged": counts[string(providers.ActionUnchanged)],
+		"failed":    counts["failed"],
+		"skipped":   counts["skipped"],
+	})
+	message := fmt.Sprintf("Update finished: %d records, %d created, %d updated, %d unchanged, %d failed, %d skipped",
+		len(s.Results), counts[string(providers.ActionCreated)], counts[string(providers.ActionUpdated)],
+		counts[string(providers.ActionUnchanged)], counts["failed"], counts["skipped"])
+	if counts["failed"] > 0 {
+		entry.Warn(message)
+	} else {
+		entry.Info(message)
+	}
+}
+
+// exitCode tells cron jobs and timers how the run went. Records skipped for
+// lack of an IP address count as failures; when no record could be updated
+// because no address was detected at all, the run failed on IP detection.
+func (s runSummary) exitCode() int {
+	counts := s.counts()
+	failed := counts["failed"] + counts["skipped"]
+
+	switch {
+	case failed == 0:
+		return exitOK
+	case counts["skipped"] == len(s.Results):
+		return exitIPUnavailable
+	case failed < len(s.Results):
+		return exitPartial
+	default:
+		return exitFailed
+	}
+}
+
+type jsonSummary struct {
+	IPv4     string       `json:"ipv4,omitempty"`
+	IPv6     string       `json:"ipv6,omitempty"`
+	ExitCode int          `json:"exitCode"`
+	Records  []jsonRecord `json:"records"`
+}
+
+type jsonRecord struct {
+	Provider string `json:"provider"`
+	Record   string `json:"record"`
+	Type     string `json:"type"`
+	Content  string `json:"content,omitempty"`
+	Action   string `json:"action"`
+	Error    string `json:"error,omitempty"`
+}
+
+// writeJSON writes the summary as a single JSON document.
+func (s runSummary) writeJSON(w io.Writer) error {
+	out := jsonSummary{
+		IPv4:     s.IPv4,
+		IPv6:     s.IPv6,
+		ExitCode: s.exitCode(),
+		Records:  make([]jsonRecord, 0, len(s.Results)),
+	}
+	for _, result := range s.Results {
+		record := jsonRecord{
+			Provider: result.Provider,
+			Record:   result.Name,
+			Type:     result.Type,
+			Content:  result.Content,
+			Action:   result.outcome(),
+		}
+		if result.Err != nil {
+			record.Error = result.Err.Error()
+		}
+		out.Records = append(out.Records, record)
+	}
+	return json.NewEncoder(w).Encode(out)
+}
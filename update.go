@@ -0,0 +1,492 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cfddns/config"
+	"cfddns/ipfetcher"
+	"cfddns/metrics"
+	"cfddns/notify"
+	"cfddns/providers"
+	"cfddns/providers/clouddns"
+	"cfddns/providers/cloudflare"
+	"cfddns/providers/digitalocean"
+	"cfddns/providers/duckdns"
+	"cfddns/providers/dynu"
+	"cfddns/providers/freedns"
+	"cfddns/providers/noip"
+	"cfddns/providers/route53"
+	"cfddns/status"
+
+	"github.com/sirupsen/logrus"
+)
+
+type rateLimit struct {
+	perSecond float64
+	burst     int
+}
+
+// defaultRateLimits keep each provider type below its documented API limits
+// unless rateLimit and rateBurst are set.
+var defaultRateLimits = map[string]rateLimit{
+	"cloudflare":   {4, 20}, // 1200 requests per 5 minutes
+	"route53":      {5, 5},  // 5 requests per second per account
+	"digitalocean": {4, 10}, // 250 requests per minute
+	"clouddns":     {5, 10},
+	"duckdns":      {1, 5},
+	"noip":         {1, 5},
+	"freedns":      {1, 5},
+	"dynu":         {1, 5},
+}
+
+// Provider instances are kept across runs so their rate limiters, circuit
+// breakers and cached lookups survive. They are keyed by type and settings:
+// configs sharing an account share an instance, and a reload that changes
+// credentials or limits builds a new one.
+var (
+	providerCacheMu sync.Mutex
+	providerCache   = make(map[string]*providerInstance)
+)
+
+type providerInstance struct {
+	provider providers.Provider
+	breaker  *providers.Breaker
+}
+
+func providerKey(providerCfg config.ProviderConfig) string {
+	return providerCfg.Type + fmt.Sprint(providerCfg.Settings)
+}
+
+func getProvider(providerCfg config.ProviderConfig, breakerCfg config.CircuitBreakerConfig) (*providerInstance, error) {
+	key := providerKey(providerCfg)
+
+	providerCacheMu.Lock()
+	defer providerCacheMu.Unlock()
+
+	if instance, ok := providerCache[key]; ok {
+		return instance, nil
+	}
+	provider, err := buildProvider(providerCfg)
+	if err != nil {
+		return nil, err
+	}
+	instance := &providerInstance{
+		provider: provider,
+		breaker: providers.NewBreaker(
+			breakerCfg.FailureThreshold,
+			time.Duration(breakerCfg.Cooldown)*time.Second,
+			time.Duration(breakerCfg.MaxCooldown)*time.Second,
+		),
+	}
+	providerCache[key] = instance
+	return instance, nil
+}
+
+// pruneProviders drops cached provider instances no longer used by cfg.
+func pruneProviders(cfg *config.Config) {
+	used := make(map[string]bool)
+	for _, providerCfg := range cfg.Providers {
+		used[providerKey(providerCfg)] = true
+	}
+
+	providerCacheMu.Lock()
+	defer providerCacheMu.Unlock()
+	for key := range providerCache {
+		if !used[key] {
+			delete(providerCache, key)
+		}
+	}
+}
+
+// pruneRecordStatus forgets the status and metrics of records that are no
+// longer in cfg.
+func pruneRecordStatus(cfg *config.Config) {
+	used := make(map[string]bool)
+	for _, providerCfg := range cfg.Providers {
+		for _, record := range providerCfg.Records {
+			used[providerCfg.Type+"/"+record.Name+"/"+record.Type] = true
+		}
+	}
+	status.PruneRecords(func(provider, name, recordType string) bool {
+		if used[provider+"/"+name+"/"+recordType] {
+			return true
+		}
+		metrics.DeleteRecord(provider, name, recordType)
+		return false
+	})
+}
+
+func newLimiter(providerCfg config.ProviderConfig) *providers.Limiter {
+	limit := defaultRateLimits[providerCfg.Type]
+	if perSecond, ok := config.NumberSetting(providerCfg.Settings, "rateLimit"); ok {
+		limit.perSecond = perSecond
+	}
+	if burst, ok := config.NumberSetting(providerCfg.Settings, "rateBurst"); ok {
+		limit.burst = int(burst)
+	}
+	return providers.NewLimiter(limit.perSecond, limit.burst)
+}
+
+func buildProvider(providerCfg config.ProviderConfig) (providers.Provider, error) {
+	settings := providerCfg.Settings
+	limiter := newLimiter(providerCfg)
+
+	switch providerCfg.Type {
+	case "cloudflare":
+		email, _ := settings["email"].(string)
+		apiToken, _ := settings["apiToken"].(string)
+		globalAPIKey, _ := settings["globalApiKey"].(string)
+		zoneName, _ := settings["zone"].(string)
+		zoneID, _ := settings["zoneId"].(string)
+		accountID, _ := settings["accountId"].(string)
+		marker, ok := settings["ownershipMarker"].(string)
+		if !ok {
+			marker = cloudflare.DefaultOwnershipMarker
+		}
+		markWith, _ := settings["markWith"].(string)
+		enforceOwnership, _ := settings["enforceOwnership"].(bool)
+
+		return &cloudflare.CloudflareProvider{
+			Email:            email,
+			APIToken:         apiToken,
+			GlobalAPIKey:     globalAPIKey,
+			ZoneName:         zoneName,
+			ZoneID:           zoneID,
+			AccountID:        accountID,
+			Limiter:          limiter,
+			OwnershipMarker:  marker,
+			MarkWith:         markWith,
+			EnforceOwnership: enforceOwnership,
+		}, nil
+
+	case "route53":
+		zoneName, _ := settings["zone"].(string)
+		region, _ := settings["region"].(string)
+		accessKeyID, _ := settings["accessKeyId"].(string)
+		secretAccessKey, _ := settings["secretAccessKey"].(string)
+		profile, _ := settings["profile"].(string)
+		roleARN, _ := settings["roleArn"].(string)
+		externalID, _ := settings["externalId"].(string)
+		zoneID, _ := settings["zoneId"].(string)
+		privateZone, _ := settings["privateZone"].(bool)
+		waitForSync, _ := settings["waitForSync"].(bool)
+		if region == "" {
+			region = "us-east-1"
+		}
+
+		return &route53.Route53Provider{
+			ZoneName:        zoneName,
+			ZoneID:          zoneID,
+			Region:          region,
+			AccessKeyID:     accessKeyID,
+			SecretAccessKey: secretAccessKey,
+			Profile:         profile,
+			RoleARN:         roleARN,
+			ExternalID:      externalID,
+			PrivateZone:     privateZone,
+			WaitForSync:     waitForSync,
+			Limiter:         limiter,
+		}, nil
+
+	case "digitalocean":
+		apiToken, _ := settings["apiToken"].(string)
+		domain, _ := settings["domain"].(string)
+
+		return &digitalocean.DigitalOceanProvider{
+			APIToken: apiToken,
+			Domain:   domain,
+			Limiter:  limiter,
+		}, nil
+
+	case "clouddns":
+		projectID, _ := settings["projectId"].(string)
+		credentialsJSONPath, _ := settings["credentialsJsonPath"].(string)
+		zoneName, _ := settings["zone"].(string)
+
+		// Read the credentials JSON file
+		credentialsJSON, err := os.ReadFile(credentialsJSONPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read credentials JSON file: %v", err)
+		}
+
+		return &clouddns.CloudDNSProvider{
+			ProjectID:       projectID,
+			CredentialsJSON: credentialsJSON,
+			ZoneName:        zoneName,
+			Limiter:         limiter,
+		}, nil
+
+	case "duckdns":
+		token, _ := settings["token"].(string)
+
+		return &duckdns.DuckDNSProvider{
+			Token:   token,
+			Limiter: limiter,
+		}, nil
+
+	case "noip":
+		username, _ := settings["username"].(string)
+		password, _ := settings["password"].(string)
+
+		return &noip.NoIPProvider{
+			Username: username,
+			Password: password,
+			Limiter:  limiter,
+		}, nil
+
+	case "freedns":
+		return &freedns.FreeDNSProvider{
+			Limiter: limiter,
+		}, nil
+
+	case "dynu":
+		username, _ := settings["username"].(string)
+		password, _ := settings["password"].(string)
+
+		return &dynu.DynuProvider{
+			Username: username,
+			Password: password,
+			Limiter:  limiter,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported provider type: %s", providerCfg.Type)
+}
+
+// runOnce updates every configured record with the current external IPs.
+// Provider instances are updated concurrently, at most maxConcurrency at a
+// time, while the records of each instance are committed one after another.
+func runOnce(cfg *config.Config, notifier *notify.Dispatcher) runSummary {
+	ipv4Address, err := ipfetcher.GetExternalIP()
+	if err != nil {
+		logrus.WithField("family", "ipv4").WithError(err).Warnf("Error fetching external IPv4 address: %v", err)
+		ipv4Address = ""
+	}
+
+	ipv6Address, err := ipfetcher.GetExternalIPv6()
+	if err != nil {
+		logrus.WithField("family", "ipv6").WithError(err).Warnf("Error fetching external IPv6 address: %v", err)
+		ipv6Address = ""
+	}
+
+	// Configs that share a provider instance, such as blocks for one zone
+	// spread over fragments, are updated together so they batch as one
+	var keys []string
+	groups := make(map[string][]config.ProviderConfig)
+	for _, providerCfg := range cfg.Providers {
+		key := providerKey(providerCfg)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], providerCfg)
+	}
+
+	providerResults := make([][]recordResult, len(keys))
+	workers := make(chan struct{}, cfg.GeneralSettings.MaxConcurrency)
+	var wg sync.WaitGroup
+
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, providerCfgs []config.ProviderConfig) {
+			defer wg.Done()
+			workers <- struct{}{}
+			defer func() { <-workers }()
+
+			providerResults[i] = updateProvider(mergeRecords(providerCfgs), cfg.GeneralSettings.CircuitBreaker, ipv4Address, ipv6Address, notifier)
+		}(i, groups[key])
+	}
+	wg.Wait()
+
+	var results []recordResult
+	for _, providerResult := range providerResults {
+		results = append(results, providerResult...)
+	}
+	summary := runSummary{IPv4: ipv4Address, IPv6: ipv6Address, Results: results}
+	summary.log()
+	return summary
+}
+
+// mergeRecords combines configs that share a provider instance, and so have
+// the same type and settings, into one holding all their records.
+func mergeRecords(providerCfgs []config.ProviderConfig) config.ProviderConfig {
+	merged := providerCfgs[0]
+	merged.Records = nil
+	for _, providerCfg := range providerCfgs {
+		merged.Records = append(merged.Records, providerCfg.Records...)
+	}
+	return merged
+}
+
+// updateProvider commits the records of one provider, in order or, when
+// the provider supports it, as one batch. Records are failed without
+// calling the provider while its circuit breaker is open.
+func updateProvider(providerCfg config.ProviderConfig, breakerCfg config.CircuitBreakerConfig, ipv4Address, ipv6Address string, notifier *notify.Dispatcher) []recordResult {
+	results := make([]recordResult, len(providerCfg.Records))
+
+	instance, err := getProvider(providerCfg, breakerCfg)
+	if err != nil {
+		logrus.WithField("provider", providerCfg.Type).WithError(err).Errorf("Error configuring provider %s: %v", providerCfg.Type, err)
+		for i, record := range providerCfg.Records {
+			results[i] = recordResult{Provider: providerCfg.Type, Name: record.Name, Type: record.Type, Err: err}
+		}
+		return results
+	}
+
+	// pending holds the indexes of the records that have an IP address
+	var pending []int
+	dnsRecords := make([]providers.DNSRecord, len(providerCfg.Records))
+	for i, record := range providerCfg.Records {
+		var ipAddress string
+
+		if record.Type == "A" && ipv4Address != "" {
+			ipAddress = ipv4Address
+		} else if record.Type == "AAAA" && ipv6Address != "" {
+			ipAddress = ipv6Address
+		} else {
+			logrus.WithFields(logrus.Fields{
+				"provider": providerCfg.Type,
+				"record":   record.Name,
+				"type":     record.Type,
+				"action":   "skipped",
+			}).Warnf("Skipping record %s of type %s due to missing IP", record.Name, record.Type)
+			results[i] = recordResult{Provider: providerCfg.Type, Name: record.Name, Type: record.Type, Skipped: true}
+			continue
+		}
+
+		dnsRecords[i] = providers.DNSRecord{
+			Name:        record.Name,
+			Type:        record.Type,
+			Content:     ipAddress,
+			TTL:         record.TTL,
+			Proxied:     record.Proxied,
+			UpdateToken: record.UpdateToken,
+			Duplicates:  record.Duplicates,
+			Adopt:       record.Adopt,
+			Target:      record.Target,
+			Comment:     record.Comment,
+
+			SetIdentifier: record.SetIdentifier,
+			Weight:        record.Weight,
+			Failover:      strings.ToUpper(record.Failover),
+			Region:        record.Region,
+			HealthCheckID: record.HealthCheckID,
+		}
+		pending = append(pending, i)
+	}
+
+	var disabledLogged bool
+	allow := func(i int) bool {
+		ok, until := instance.breaker.Allow()
+		if ok {
+			return true
+		}
+		dnsRecord := dnsRecords[i]
+		err := fmt.Errorf("%s provider is disabled until %s after repeated permanent failures", providerCfg.Type, until.Format(time.RFC3339))
+		if !disabledLogged {
+			logrus.WithField("provider", providerCfg.Type).Warnf("Skipping %s records: %v", providerCfg.Type, err)
+			disabledLogged = true
+		}
+		status.SetRecordResult(providerCfg.Type, dnsRecord.Name, dnsRecord.Type, dnsRecord.Content, err)
+		results[i] = recordResult{Provider: providerCfg.Type, Name: dnsRecord.Name, Type: dnsRecord.Type, Content: dnsRecord.Content, Err: err}
+		return false
+	}
+
+	finish := func(i int, action providers.Action, err error, elapsed time.Duration) {
+		dnsRecord := dnsRecords[i]
+		metrics.ObserveCommit(providerCfg.Type, dnsRecord.Name, dnsRecord.Type, elapsed, err)
+		status.SetRecordResult(providerCfg.Type, dnsRecord.Name, dnsRecord.Type, dnsRecord.Content, err)
+		results[i] = recordResult{
+			Provider: providerCfg.Type,
+			Name:     dnsRecord.Name,
+			Type:     dnsRecord.Type,
+			Content:  dnsRecord.Content,
+			Action:   action,
+			Err:      err,
+		}
+		if err != nil {
+			providers.Logger(providerCfg.Type, dnsRecord).WithError(err).Errorf("Error updating DNS record for %s: %v", dnsRecord.Name, err)
+			notifier.RecordFailed(providerCfg.Type, dnsRecord, err)
+			return
+		}
+		notifier.RecordCommitted(providerCfg.Type, dnsRecord, action)
+	}
+
+	// recordOutcome feeds the circuit breaker the outcome of one call to the
+	// provider, err being nil when it succeeded.
+	recordOutcome := func(err error) {
+		if err == nil {
+			if instance.breaker.Success() {
+				logrus.WithFields(logrus.Fields{
+					"provider": providerCfg.Type,
+					"action":   "enabled",
+				}).Infof("%s provider recovered, re-enabling it", providerCfg.Type)
+			}
+			return
+		}
+		if opened, cooldown := instance.breaker.Failure(err); opened {
+			logrus.WithFields(logrus.Fields{
+				"provider": providerCfg.Type,
+				"action":   "disabled",
+			}).Errorf("Disabling %s provider for %s after %d permanent failures in a row", providerCfg.Type, cooldown, breakerCfg.FailureThreshold)
+			notifier.ProviderDisabled(providerCfg.Type, err, breakerCfg.FailureThreshold, cooldown)
+		} else if cooldown > 0 {
+			logrus.WithField("provider", providerCfg.Type).Warnf("%s provider is still failing, next attempt in %s", providerCfg.Type, cooldown)
+		}
+	}
+
+	if batcher, ok := instance.provider.(providers.BatchCommitter); ok && len(pending) > 1 {
+		var batch []int
+		for _, i := range pending {
+			if allow(i) {
+				batch = append(batch, i)
+			}
+		}
+		if len(batch) == 0 {
+			return results
+		}
+		records := make([]providers.DNSRecord, len(batch))
+		for j, i := range batch {
+			records[j] = dnsRecords[i]
+		}
+		start := time.Now()
+		commits := batcher.CommitRecords(records)
+		// Spread the time of the call over its records
+		elapsed := time.Since(start) / time.Duration(len(batch))
+		for j, i := range batch {
+			finish(i, commits[j].Action, commits[j].Err, elapsed)
+		}
+		recordOutcome(batchOutcome(commits))
+		return results
+	}
+
+	for _, i := range pending {
+		if !allow(i) {
+			continue
+		}
+		start := time.Now()
+		action, err := instance.provider.CommitRecord(dnsRecords[i])
+		finish(i, action, err, time.Since(start))
+		recordOutcome(err)
+	}
+	return results
+}
+
+// batchOutcome reduces the results of a batch commit to the outcome of the
+// call for the circuit breaker: a success when any record was committed,
+// otherwise the first permanent error, or else the first error.
+func batchOutcome(commits []providers.CommitResult) error {
+	var first error
+	for _, commit := range commits {
+		if commit.Err == nil {
+			return nil
+		}
+		if first == nil || (providers.IsPermanent(commit.Err) && !providers.IsPermanent(first)) {
+			first = commit.Err
+		}
+	}
+	return first
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"cfddns/config"
+	"cfddns/providers"
+)
+
+func TestBatchOutcome(t *testing.T) {
+	transient := errors.New("timeout")
+	permanent := providers.Permanent(errors.New("invalid credentials"))
+
+	tests := []struct {
+		name    string
+		commits []providers.CommitResult
+		want    error
+	}{
+		{"all committed", []providers.CommitResult{{Action: providers.ActionUpdated}, {Action: providers.ActionUnchanged}}, nil},
+		{"some committed", []providers.CommitResult{{Err: permanent}, {Action: providers.ActionUpdated}}, nil},
+		{"all failed", []providers.CommitResult{{Err: transient}, {Err: transient}}, transient},
+		{"permanent failure preferred", []providers.CommitResult{{Err: transient}, {Err: permanent}, {Err: transient}}, permanent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := batchOutcome(tt.commits); got != tt.want {
+				t.Errorf("batchOutcome() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeRecords(t *testing.T) {
+	settings := map[string]interface{}{"zone": "example.com"}
+	providerCfgs := []config.ProviderConfig{
+		{Type: "cloudflare", Settings: settings, Source: "cfddns.yml", Records: []config.DNSRecord{{Name: "home.example.com", Type: "A"}}},
+		{Type: "cloudflare", Settings: settings, Source: "conf.d/web.yml", Records: []config.DNSRecord{{Name: "www.example.com", Type: "A"}, {Name: "www.example.com", Type: "AAAA"}}},
+	}
+
+	merged := mergeRecords(providerCfgs)
+	if len(merged.Records) != 3 || merged.Records[0].Name != "home.example.com" || merged.Records[2].Type != "AAAA" {
+		t.Errorf("mergeRecords() records = %+v, want the records of both configs in order", merged.Records)
+	}
+	if len(providerCfgs[0].Records) != 1 {
+		t.Errorf("mergeRecords() modified the records of the first config")
+	}
+}